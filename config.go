@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/mc2soft/postgresql_exporter/metrics"
+)
+
+const (
+	targetPostgreSQL = "postgresql"
+	targetPgBouncer  = "pgbouncer"
+)
+
+const (
+	logFormatCSV  = "csvlog"
+	logFormatJSON = "jsonlog"
+)
+
+type Config struct {
+	// Namespace is a prefix of metric names, overrides metrics.namespace flag
+	Namespace string `yaml:"namespace"`
+	// ConstLabels are added to all metrics, including exporter's own
+	ConstLabels map[string]string `yaml:"const_labels"`
+	// Filter drops metrics of all targets before exposition
+	Filter  FilterConfig   `yaml:"filter"`
+	Targets []TargetConfig `yaml:"targets"`
+}
+
+type TargetConfig struct {
+	// Name is exported as target label, may be empty for single target
+	Name string `yaml:"name"`
+	// Type is postgresql (default) or pgbouncer
+	Type string `yaml:"type"`
+	DSN  string `yaml:"dsn"`
+
+	// Credentials are read from files or fetched by a command when set
+	UserFile        string `yaml:"user_file"`
+	PasswordFile    string `yaml:"password_file"`
+	PasswordCommand string `yaml:"password_command"`
+
+	Databases []string `yaml:"databases"`
+	Tables    []string `yaml:"tables"`
+	// ConstLabels are added to all metrics of the target, they override global ones
+	ConstLabels map[string]string `yaml:"const_labels"`
+
+	// LogDirectory is log_directory of the server, csvlog or jsonlog files
+	// in it are followed when set. LogFormat is csvlog (default) or jsonlog.
+	LogDirectory string `yaml:"log_directory"`
+	LogFormat    string `yaml:"log_format"`
+
+	// HeartbeatMode is auto (default) to write heartbeat on primary and read
+	// it on standby, or read to only read it, e.g. on logical subscriber
+	HeartbeatMode string `yaml:"heartbeat_mode"`
+
+	// Connection pool settings, flag values are used when not set
+	MaxOpenConns    *int          `yaml:"max_open_conns"`
+	MaxIdleConns    *int          `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	tableNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+func checkLabelNames(labels map[string]string) {
+	for name := range labels {
+		if !labelNameRe.MatchString(name) || strings.HasPrefix(name, "__") {
+			log.Fatalf("invalid label name %q", name)
+		}
+	}
+}
+
+// checkNamespace makes sure that namespace gives valid metric names, empty
+// one means no prefix
+func checkNamespace(ns string) {
+	if ns != "" && !metricNameRe.MatchString(ns) {
+		log.Fatalf("invalid namespace %q", ns)
+	}
+}
+
+func checkHeartbeatMode(mode string) {
+	if mode != metrics.HeartbeatAuto && mode != metrics.HeartbeatRead {
+		log.Fatalf("unknown heartbeat mode %q", mode)
+	}
+}
+
+// parseLabels parses comma-separated list of name=value pairs
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			log.Fatalf("invalid label %q, expected name=value", pair)
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	checkLabelNames(labels)
+
+	return labels
+}
+
+// open returns connections pool of the target, dbName overrides database
+// of DSN when not empty
+func (t TargetConfig) open(dbName string) *sql.DB {
+	return sql.OpenDB(&connector{
+		dsn:    t.DSN,
+		dbName: dbName,
+		creds: &credentials{
+			userFile:        t.UserFile,
+			passwordFile:    t.PasswordFile,
+			passwordCommand: t.PasswordCommand,
+		},
+	})
+}
+
+// configurePool applies pool settings of the target or defaults from flags
+func (t TargetConfig) configurePool(db *sql.DB) {
+	maxOpen, maxIdle := *maxOpenConns, *maxIdleConns
+	if t.MaxOpenConns != nil {
+		maxOpen = *t.MaxOpenConns
+	}
+	if t.MaxIdleConns != nil {
+		maxIdle = *t.MaxIdleConns
+	}
+	lifetime, idleTime := *connMaxLifetime, *connMaxIdleTime
+	if t.ConnMaxLifetime > 0 {
+		lifetime = t.ConnMaxLifetime
+	}
+	if t.ConnMaxIdleTime > 0 {
+		idleTime = t.ConnMaxIdleTime
+	}
+
+	db.SetMaxIdleConns(maxIdle)
+	db.SetMaxOpenConns(maxOpen)
+	db.SetConnMaxLifetime(lifetime)
+	db.SetConnMaxIdleTime(idleTime)
+}
+
+func parseConfig(configPath string) (cfg Config) {
+	f, err := os.Open(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = yaml.Unmarshal(b, &cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	checkNamespace(cfg.Namespace)
+	checkLabelNames(cfg.ConstLabels)
+
+	if len(cfg.Targets) == 0 {
+		log.Fatal("please specify at least one target in config")
+	}
+
+	names := make(map[string]struct{})
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		if _, ok := names[t.Name]; ok {
+			log.Fatalf("target names must be unique, %q is used twice", t.Name)
+		}
+		names[t.Name] = struct{}{}
+		if t.Type == "" {
+			t.Type = targetPostgreSQL
+		}
+		if t.Type != targetPostgreSQL && t.Type != targetPgBouncer {
+			log.Fatalf("unknown type %q of target %q", t.Type, t.Name)
+		}
+		checkLabelNames(t.ConstLabels)
+		if t.DSN == "" {
+			log.Fatalf("dsn of target %q is empty", t.Name)
+		}
+		if t.LogFormat == "" {
+			t.LogFormat = logFormatCSV
+		}
+		if t.LogFormat != logFormatCSV && t.LogFormat != logFormatJSON {
+			log.Fatalf("unknown log format %q of target %q", t.LogFormat, t.Name)
+		}
+		if t.HeartbeatMode == "" {
+			t.HeartbeatMode = *heartbeatMode
+		}
+		checkHeartbeatMode(t.HeartbeatMode)
+		if t.Type == targetPostgreSQL && len(t.Databases) == 0 {
+			log.Fatalf("please specify at least one database for target %q", t.Name)
+		}
+	}
+
+	return
+}
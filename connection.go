@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	errorClassAuth               = "auth"
+	errorClassNetwork            = "network"
+	errorClassTooManyConnections = "too_many_connections"
+	errorClassTLS                = "tls"
+	errorClassOther              = "other"
+)
+
+// errorClass returns class of connection error for connection errors metric
+func errorClass(err error) string {
+	switch e := err.(type) {
+	case *pq.Error:
+		switch e.Code {
+		case "28000", "28P01":
+			// invalid_authorization_specification, invalid_password
+			return errorClassAuth
+		case "53300":
+			// too_many_connections
+			return errorClassTooManyConnections
+		}
+		return errorClassOther
+	case tls.RecordHeaderError, x509.CertificateInvalidError, x509.HostnameError, x509.UnknownAuthorityError:
+		return errorClassTLS
+	case net.Error:
+		return errorClassNetwork
+	}
+
+	if err == pq.ErrSSLNotSupported || err == pq.ErrSSLKeyHasWorldPermissions {
+		return errorClassTLS
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errorClassNetwork
+	}
+
+	msg := err.Error()
+	if strings.HasPrefix(msg, "tls:") || strings.HasPrefix(msg, "x509:") {
+		return errorClassTLS
+	}
+
+	return errorClassOther
+}
+
+// recordingDialer dials like lib/pq does and keeps the connection, so its
+// deadline can be set. Deadline of context covers connection startup too.
+type recordingDialer struct {
+	ctx  context.Context
+	conn net.Conn
+}
+
+func (d *recordingDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialTimeout(network, address, 0)
+}
+
+func (d *recordingDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(d.ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := d.ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	d.conn = conn
+
+	return conn, nil
+}
+
+// deadlineConn applies deadline of context to network connection, lib/pq
+// doesn't support contexts, so hung queries can't be cancelled otherwise.
+// Deadline covers reading rows too, it is cleared before the connection is
+// reused. Timed out connection is broken and is dropped from the pool.
+type deadlineConn struct {
+	driver.Conn
+	netConn net.Conn
+}
+
+func (c *deadlineConn) setDeadline(ctx context.Context) {
+	deadline, _ := ctx.Deadline()
+	c.netConn.SetDeadline(deadline)
+}
+
+func (c *deadlineConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.setDeadline(ctx)
+	return c.Conn.(driver.Queryer).Query(query, values(args))
+}
+
+func (c *deadlineConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.setDeadline(ctx)
+	return c.Conn.(driver.Execer).Exec(query, values(args))
+}
+
+func (c *deadlineConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.setDeadline(ctx)
+	return c.Conn.Prepare(query)
+}
+
+// Close closes network connection even when lib/pq refuses to close broken one
+func (c *deadlineConn) Close() error {
+	err := c.Conn.Close()
+	c.netConn.Close()
+
+	return err
+}
+
+func (c *deadlineConn) ResetSession(ctx context.Context) error {
+	return c.netConn.SetDeadline(time.Time{})
+}
+
+// values drops names of arguments, lib/pq supports only positional ones
+func values(args []driver.NamedValue) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, arg := range args {
+		vals[i] = arg.Value
+	}
+
+	return vals
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// credentials supplies user and password for new connections. Files are
+// cached and re-read after connection errors, so rotated secrets are picked
+// up. Password command is run for each new connection.
+type credentials struct {
+	mutex           sync.Mutex
+	userFile        string
+	passwordFile    string
+	passwordCommand string
+
+	user     string
+	password string
+	loaded   bool
+	// commandPassword is the last password printed by command
+	commandPassword string
+	// pgpassPassword is the last password found in pgpass
+	pgpassPassword string
+}
+
+func (c *credentials) get(ctx context.Context) (user, password string, err error) {
+	c.mutex.Lock()
+	if !c.loaded {
+		var password string
+		c.user, err = readSecretFile(c.userFile)
+		if err == nil {
+			password, err = readSecretFile(c.passwordFile)
+		}
+		if err != nil {
+			c.mutex.Unlock()
+			return "", "", err
+		}
+		replaceSecret(c.password, password)
+		c.password = password
+		c.loaded = true
+	}
+	user, password = c.user, c.password
+	c.mutex.Unlock()
+
+	if c.passwordCommand != "" {
+		out, err := exec.CommandContext(ctx, "sh", "-c", c.passwordCommand).Output()
+		if err != nil {
+			return "", "", errors.New("error running password command: " + err.Error())
+		}
+		password = strings.TrimRight(string(out), "\r\n")
+
+		c.mutex.Lock()
+		replaceSecret(c.commandPassword, password)
+		c.commandPassword = password
+		c.mutex.Unlock()
+	}
+
+	return user, password, nil
+}
+
+// setPgpassPassword registers password found in pgpass as a secret, it is
+// looked up for each connection, so only a changed password is registered
+func (c *credentials) setPgpassPassword(password string) {
+	c.mutex.Lock()
+	replaceSecret(c.pgpassPassword, password)
+	c.pgpassPassword = password
+	c.mutex.Unlock()
+}
+
+// invalidate makes files to be read again on next connection
+func (c *credentials) invalidate() {
+	c.mutex.Lock()
+	c.loaded = false
+	c.mutex.Unlock()
+}
+
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.New("error reading credentials file: " + err.Error())
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// connector opens connections to the target resolving service and password
+// from credentials, password files and pgpass, which lib/pq doesn't support.
+type connector struct {
+	dsn string
+	// dbName overrides database of DSN and service
+	dbName string
+	creds  *credentials
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn, err := c.resolveDSN(ctx)
+	if err != nil {
+		return nil, redactError(err)
+	}
+
+	dialer := &recordingDialer{ctx: ctx}
+	conn, err := pq.DialOpen(dialer, dsn)
+	if err != nil {
+		c.creds.invalidate()
+		return nil, redactError(err)
+	}
+	dialer.conn.SetDeadline(time.Time{})
+
+	return &deadlineConn{Conn: conn, netConn: dialer.conn}, nil
+}
+
+func (c *connector) resolveDSN(ctx context.Context) (string, error) {
+	opts, err := parseDSN(c.dsn)
+	if err != nil {
+		return "", err
+	}
+
+	service := opts["service"]
+	if service == "" {
+		service = clientGetenv("PGSERVICE")
+	}
+	delete(opts, "service")
+	if service != "" {
+		params, ok := serviceParams(service)
+		if !ok {
+			return "", errors.New("definition of service \"" + service + "\" not found")
+		}
+		for key, value := range params {
+			if _, ok := opts[key]; !ok {
+				opts[key] = value
+			}
+		}
+	}
+
+	if c.dbName != "" {
+		opts["dbname"] = c.dbName
+	}
+
+	user, password, err := c.creds.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	if user != "" {
+		opts["user"] = user
+	}
+	if password != "" {
+		opts["password"] = password
+	}
+	if opts["password"] == "" && os.Getenv("PGPASSWORD") == "" {
+		if password := pgpassPassword(opts); password != "" {
+			c.creds.setPgpassPassword(password)
+			opts["password"] = password
+		}
+	}
+
+	return buildDSN(opts), nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return pqDriver{}
+}
+
+type pqDriver struct{}
+
+func (pqDriver) Open(name string) (driver.Conn, error) {
+	return pq.Open(name)
+}
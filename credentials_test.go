@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setClientEnv sets variables taken by takeClientEnv for the test and
+// restores both environment and taken variables after it
+func setClientEnv(t *testing.T, env map[string]string) {
+	clientEnvMutex.Lock()
+	saved := clientEnv
+	clientEnv = make(map[string]string)
+	clientEnvMutex.Unlock()
+	t.Cleanup(func() {
+		clientEnvMutex.Lock()
+		clientEnv = saved
+		clientEnvMutex.Unlock()
+	})
+
+	for _, name := range libpqOnlyEnv {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	takeClientEnv()
+}
+
+func TestConnectWithPgpassFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pgpass")
+	err := ioutil.WriteFile(path, []byte("*:*:*:exporter:pgpass-secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PGPASSWORD", "")
+	os.Unsetenv("PGPASSWORD")
+	setClientEnv(t, map[string]string{"PGPASSFILE": path})
+
+	if _, ok := os.LookupEnv("PGPASSFILE"); ok {
+		t.Fatal("PGPASSFILE is left in environment")
+	}
+
+	c := &connector{
+		dsn:   "host=127.0.0.1 port=1 user=exporter dbname=postgres sslmode=disable connect_timeout=1",
+		creds: &credentials{},
+	}
+	_, err = c.Connect(context.Background())
+	if err == nil {
+		t.Fatal("expected connection error")
+	}
+	if strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("lib/pq saw PGPASSFILE: %s", err)
+	}
+
+	dsn, err := c.resolveDSN(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "password='pgpass-secret'") {
+		t.Fatalf("password from PGPASSFILE is not used: %s", redact(dsn))
+	}
+	if redact("pgpass-secret") != "xxx" {
+		t.Fatal("password from PGPASSFILE is not redacted")
+	}
+
+	// password is looked up for each connection, but registered once
+	secretsMutex.RLock()
+	count := secrets["pgpass-secret"]
+	secretsMutex.RUnlock()
+	if count != 1 {
+		t.Fatalf("password from PGPASSFILE is registered %d times", count)
+	}
+}
+
+func TestResolveDSNWithService(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg_service.conf")
+	err := ioutil.WriteFile(path, []byte("[main]\nhost=db.example.com\nport=6432\ndbname=app\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setClientEnv(t, map[string]string{"PGSERVICEFILE": path, "PGSERVICE": "main"})
+
+	c := &connector{dsn: "port=5433", dbName: "other", creds: &credentials{}}
+	dsn, err := c.resolveDSN(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DSN takes precedence over service, database of the pool over both
+	expected := "dbname='other' host='db.example.com' port='5433'"
+	if dsn != expected {
+		t.Fatalf("expected %q, got %q", expected, dsn)
+	}
+
+	c = &connector{dsn: "service=missing", creds: &credentials{}}
+	_, err = c.resolveDSN(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `"missing" not found`) {
+		t.Fatalf("expected missing service error, got %v", err)
+	}
+}
+
+func TestCredentialsReplaceSecrets(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	tokenFile := filepath.Join(dir, "token")
+	for path, content := range map[string]string{passwordFile: "file-secret-1\n", tokenFile: "token-1"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &credentials{passwordFile: passwordFile, passwordCommand: "cat " + tokenFile}
+	_, password, err := c.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "token-1" {
+		t.Fatalf("expected password of command, got %q", password)
+	}
+
+	// rotated secrets replace previous ones
+	ioutil.WriteFile(passwordFile, []byte("file-secret-2\n"), 0600)
+	ioutil.WriteFile(tokenFile, []byte("token-2"), 0600)
+	c.invalidate()
+	if _, _, err = c.get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+	for secret, registered := range map[string]bool{
+		"file-secret-1": false,
+		"token-1":       false,
+		"file-secret-2": true,
+		"token-2":       true,
+	} {
+		if _, ok := secrets[secret]; ok != registered {
+			t.Errorf("secret %s registered %t, expected %t", secret, ok, registered)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/log"
+)
+
+// databasePool keeps connections to monitored databases of the target, they
+// are needed by collections reading catalogs, which are local to database
+type databasePool struct {
+	mutex sync.Mutex
+	cfg   TargetConfig
+	dbs   map[string]*sql.DB
+}
+
+func newDatabasePool(cfg TargetConfig) *databasePool {
+	return &databasePool{
+		cfg: cfg,
+		dbs: make(map[string]*sql.DB),
+	}
+}
+
+// get returns connections pool of database, it is opened on first use
+func (p *databasePool) get(name string) *sql.DB {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	db, ok := p.dbs[name]
+	if !ok {
+		db = p.cfg.open(name)
+		p.cfg.configurePool(db)
+		// catalog queries run one by one, so a single connection is enough,
+		// it also caps idle connections, other settings of target are kept
+		db.SetMaxOpenConns(1)
+		p.dbs[name] = db
+	}
+
+	return db
+}
+
+// pools returns opened connections pools by database name
+func (p *databasePool) pools() map[string]*sql.DB {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pools := make(map[string]*sql.DB, len(p.dbs))
+	for name, db := range p.dbs {
+		pools[name] = db
+	}
+
+	return pools
+}
+
+// prune closes pools of databases which don't exist anymore, they are opened
+// again if the database is created later
+func (p *databasePool) prune(db *sql.DB) error {
+	rows, err := db.Query("SELECT datname FROM pg_database")
+	if err != nil {
+		return errors.New("error getting databases list: " + err.Error())
+	}
+	defer rows.Close()
+
+	exist := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return errors.New("error getting databases list: " + err.Error())
+		}
+		exist[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return errors.New("error getting databases list: " + err.Error())
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for name, db := range p.dbs {
+		if !exist[name] {
+			log.Infof("closing connections to dropped database %s", name)
+			db.Close()
+			delete(p.dbs, name)
+		}
+	}
+
+	return nil
+}
+
+// close closes all pools on shutdown
+func (p *databasePool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for name, db := range p.dbs {
+		db.Close()
+		delete(p.dbs, name)
+	}
+}
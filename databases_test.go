@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// catalog is a database driver answering any query with names of DSN,
+// which is a comma-separated list
+type catalog struct{}
+
+func init() {
+	sql.Register("catalog", catalog{})
+}
+
+func (catalog) Open(dsn string) (driver.Conn, error) { return catalogConn(dsn), nil }
+
+type catalogConn string
+
+func (c catalogConn) Prepare(string) (driver.Stmt, error) { return catalogStmt(c), nil }
+func (c catalogConn) Close() error                        { return nil }
+func (c catalogConn) Begin() (driver.Tx, error)           { return nil, io.ErrUnexpectedEOF }
+
+type catalogStmt string
+
+func (s catalogStmt) Close() error  { return nil }
+func (s catalogStmt) NumInput() int { return -1 }
+func (s catalogStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+func (s catalogStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &catalogRows{names: strings.Split(string(s), ",")}, nil
+}
+
+type catalogRows struct{ names []string }
+
+func (r *catalogRows) Columns() []string { return []string{"datname"} }
+func (r *catalogRows) Close() error      { return nil }
+func (r *catalogRows) Next(dest []driver.Value) error {
+	if len(r.names) == 0 {
+		return io.EOF
+	}
+	dest[0], r.names = r.names[0], r.names[1:]
+	return nil
+}
+
+func openCatalog(t *testing.T, dsn string) *sql.DB {
+	db, err := sql.Open("catalog", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDatabasePoolPrune(t *testing.T) {
+	p := newDatabasePool(TargetConfig{})
+	dropped := openCatalog(t, "")
+	p.dbs["app"] = openCatalog(t, "")
+	p.dbs["old"] = dropped
+
+	server := openCatalog(t, "postgres,template1,app")
+	defer server.Close()
+	if err := p.prune(server); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for name := range p.pools() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "app" {
+		t.Fatalf("expected only pool of app, got %v", names)
+	}
+	if err := dropped.Ping(); err == nil {
+		t.Fatal("pool of dropped database is not closed")
+	}
+
+	db := p.dbs["app"]
+	p.close()
+	if len(p.pools()) != 0 {
+		t.Fatal("pools are kept after close")
+	}
+	if err := db.Ping(); err == nil {
+		t.Fatal("pool is not closed")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// parseDSN parses connection string in URL or key=value format
+// the same way as lib/pq does.
+func parseDSN(dsn string) (map[string]string, error) {
+	if strings.HasPrefix(dsn, "postgresql://") {
+		dsn = "postgres://" + strings.TrimPrefix(dsn, "postgresql://")
+	}
+	if strings.HasPrefix(dsn, "postgres://") {
+		var err error
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			return nil, errors.New("error parsing connection URL: " + redact(err.Error()))
+		}
+	}
+
+	opts := make(map[string]string)
+	s := []rune(dsn)
+	i := 0
+	skipSpaces := func() {
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+	}
+
+	for {
+		skipSpaces()
+		if i >= len(s) {
+			return opts, nil
+		}
+
+		var key []rune
+		for i < len(s) && s[i] != '=' && !unicode.IsSpace(s[i]) {
+			key = append(key, s[i])
+			i++
+		}
+		skipSpaces()
+		if i >= len(s) || s[i] != '=' {
+			return nil, errors.New("missing \"=\" after \"" + string(key) + "\" in connection string")
+		}
+		i++
+		skipSpaces()
+
+		var value []rune
+		if i < len(s) && s[i] == '\'' {
+			i++
+			for {
+				if i >= len(s) {
+					return nil, errors.New("unterminated quoted string in connection string")
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				} else if s[i] == '\'' {
+					i++
+					break
+				}
+				value = append(value, s[i])
+				i++
+			}
+		} else {
+			for i < len(s) && !unicode.IsSpace(s[i]) {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value = append(value, s[i])
+				i++
+			}
+		}
+
+		opts[string(key)] = string(value)
+	}
+}
+
+// buildDSN makes key=value connection string from options
+func buildDSN(opts map[string]string) string {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"='"+quote.Replace(opts[key])+"'")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+var (
+	secretsMutex sync.RWMutex
+	// secrets are counted, as the same password may be used by several pools
+	secrets = make(map[string]int)
+
+	dsnPasswordRe = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+	urlPasswordRe = regexp.MustCompile(`(://[^:/@\s]*:)[^@\s]*@`)
+)
+
+// addSecret registers password read from file or command, so it is redacted
+// from log lines and error messages even if it appears outside of DSN
+func addSecret(secret string) {
+	replaceSecret("", secret)
+}
+
+// replaceSecret registers new secret instead of old one, so rotated tokens
+// don't pile up
+func replaceSecret(old, secret string) {
+	if old == secret {
+		return
+	}
+
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	if secret != "" {
+		secrets[secret]++
+	}
+	if old != "" {
+		secrets[old]--
+		if secrets[old] <= 0 {
+			delete(secrets, old)
+		}
+	}
+}
+
+// redact hides passwords of connection strings and known secrets
+func redact(s string) string {
+	s = dsnPasswordRe.ReplaceAllString(s, "${1}xxx")
+	s = urlPasswordRe.ReplaceAllString(s, "${1}xxx@")
+
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+	for secret := range secrets {
+		s = strings.Replace(s, secret, "xxx", -1)
+	}
+
+	return s
+}
+
+// redactError keeps original error when there is nothing to hide,
+// so its type still can be checked
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+
+	return errors.New(msg)
+}
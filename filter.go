@@ -0,0 +1,207 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/log"
+)
+
+type FilterConfig struct {
+	// Allow and Deny are regexps of full metric names. Metric is exported
+	// when it matches any Allow pattern (or there are none) and no Deny pattern.
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+	// Labels drop series by label values
+	Labels []LabelFilterConfig `yaml:"labels"`
+	// Limits are maximum numbers of series by collection name, e.g. tables
+	Limits map[string]int `yaml:"limits"`
+}
+
+type LabelFilterConfig struct {
+	// Metric is regexp of full metric names the rule applies to, all when empty
+	Metric string `yaml:"metric"`
+	Label  string `yaml:"label"`
+	// Allow and Deny are regexps of label values, series without the label
+	// are not affected
+	Allow string `yaml:"allow"`
+	Deny  string `yaml:"deny"`
+}
+
+type labelFilter struct {
+	metric, allow, deny *regexp.Regexp
+	label               string
+}
+
+// metricFilter drops metrics and series before exposition, nil filter keeps everything
+type metricFilter struct {
+	allow, deny []*regexp.Regexp
+	labels      []labelFilter
+	limits      map[string]int
+}
+
+// anchored compiles pattern matching the whole string, empty pattern gives nil
+func anchored(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		log.Fatalf("invalid filter pattern %q: %s", pattern, err)
+	}
+
+	return re
+}
+
+func newMetricFilter(cfg FilterConfig) *metricFilter {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 && len(cfg.Labels) == 0 && len(cfg.Limits) == 0 {
+		return nil
+	}
+
+	f := &metricFilter{limits: cfg.Limits}
+	for _, pattern := range cfg.Allow {
+		f.allow = append(f.allow, anchored(pattern))
+	}
+	for _, pattern := range cfg.Deny {
+		f.deny = append(f.deny, anchored(pattern))
+	}
+	for _, l := range cfg.Labels {
+		if l.Label == "" {
+			log.Fatal("label of label filter is empty")
+		}
+		f.labels = append(f.labels, labelFilter{
+			metric: anchored(l.Metric),
+			label:  l.Label,
+			allow:  anchored(l.Allow),
+			deny:   anchored(l.Deny),
+		})
+	}
+	for name, limit := range cfg.Limits {
+		if limit < 0 {
+			log.Fatalf("limit of collection %q is negative", name)
+		}
+	}
+
+	return f
+}
+
+// keepMetric tells whether metric with full name is exported
+func (f *metricFilter) keepMetric(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, re := range f.deny {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, re := range f.allow {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keepSeries applies label rules to series of metric
+func (f *metricFilter) keepSeries(name string, labels []*dto.LabelPair) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, l := range f.labels {
+		if l.metric != nil && !l.metric.MatchString(name) {
+			continue
+		}
+		for _, label := range labels {
+			if label.GetName() != l.label {
+				continue
+			}
+			if l.allow != nil && !l.allow.MatchString(label.GetValue()) {
+				return false
+			}
+			if l.deny != nil && l.deny.MatchString(label.GetValue()) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// limit returns maximum number of series of collection, 0 means no limit
+func (f *metricFilter) limit(collection string) int {
+	if f == nil {
+		return 0
+	}
+
+	return f.limits[collection]
+}
+
+// needsSeries tells whether series have to be inspected one by one
+func (f *metricFilter) needsSeries(collection string) bool {
+	return f != nil && (len(f.labels) > 0 || f.limit(collection) > 0)
+}
+
+// keepCollection tells whether collection should be scraped at all. Metrics
+// of collections which create them during scrape aren't known beforehand,
+// such collections are always scraped, so collections describing some of
+// their metrics must create all of them in constructor.
+func (f *metricFilter) keepCollection(c prometheus.Collector) bool {
+	if f == nil {
+		return true
+	}
+
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	keep, described := false, false
+	for desc := range ch {
+		described = true
+		keep = keep || f.keepMetric(descName(desc))
+	}
+
+	return keep || !described
+}
+
+var descNames = struct {
+	sync.Mutex
+	names map[*prometheus.Desc]string
+}{names: make(map[*prometheus.Desc]string)}
+
+// descName returns full metric name of desc, client library doesn't expose it
+// so it is parsed from string representation, which starts with the quoted name
+func descName(desc *prometheus.Desc) string {
+	descNames.Lock()
+	defer descNames.Unlock()
+
+	if name, ok := descNames.names[desc]; ok {
+		return name
+	}
+
+	s := strings.TrimPrefix(desc.String(), "Desc{fqName: ")
+	name, err := strconv.QuotedPrefix(s)
+	if err == nil {
+		name, err = strconv.Unquote(name)
+	}
+	if err != nil {
+		log.Errorf("can't get metric name of %s", desc)
+		name = ""
+	}
+	descNames.names[desc] = name
+
+	return name
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/mc2soft/postgresql_exporter/metrics"
+)
+
+func TestKeepMetric(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    FilterConfig
+		metric string
+		keep   bool
+	}{
+		{"no filter", FilterConfig{}, "postgresql_up", true},
+		{"allowed", FilterConfig{Allow: []string{"postgresql_tables_.*"}}, "postgresql_tables_size_bytes", true},
+		{"not allowed", FilterConfig{Allow: []string{"postgresql_tables_.*"}}, "postgresql_databases_size_bytes", false},
+		{"any allow pattern", FilterConfig{Allow: []string{"postgresql_up", "postgresql_tables_.*"}}, "postgresql_up", true},
+		{"denied", FilterConfig{Deny: []string{"postgresql_tables_.*"}}, "postgresql_tables_size_bytes", false},
+		{"not denied", FilterConfig{Deny: []string{"postgresql_tables_.*"}}, "postgresql_up", true},
+		{"deny wins over allow", FilterConfig{Allow: []string{"postgresql_tables_.*"}, Deny: []string{".*_bytes"}}, "postgresql_tables_size_bytes", false},
+		{"allowed and not denied", FilterConfig{Allow: []string{"postgresql_tables_.*"}, Deny: []string{".*_bytes"}}, "postgresql_tables_pages", true},
+		{"anchored at start", FilterConfig{Allow: []string{"up"}}, "postgresql_up", false},
+		{"anchored at end", FilterConfig{Allow: []string{"postgresql_up"}}, "postgresql_up_extra", false},
+		{"alternation is anchored as a whole", FilterConfig{Deny: []string{"postgresql_up|postgresql_io_.*"}}, "postgresql_upstream", true},
+		{"label filter only keeps all metrics", FilterConfig{Labels: []LabelFilterConfig{{Label: "table", Deny: "tmp_.*"}}}, "postgresql_up", true},
+	}
+
+	for _, test := range tests {
+		f := newMetricFilter(test.cfg)
+		if keep := f.keepMetric(test.metric); keep != test.keep {
+			t.Errorf("%s: expected %t for %s, got %t", test.name, test.keep, test.metric, keep)
+		}
+	}
+}
+
+func labelPairs(pairs ...string) []*dto.LabelPair {
+	var labels []*dto.LabelPair
+	for i := 0; i < len(pairs); i += 2 {
+		labels = append(labels, &dto.LabelPair{Name: proto.String(pairs[i]), Value: proto.String(pairs[i+1])})
+	}
+
+	return labels
+}
+
+func TestKeepSeries(t *testing.T) {
+	// seq_tup_read only for orders_.* tables, other metrics keep all tables
+	// except temporary ones
+	f := newMetricFilter(FilterConfig{Labels: []LabelFilterConfig{
+		{Metric: "postgresql_tables_seq_tup_read_total", Label: "table", Allow: "orders_.*"},
+		{Label: "table", Deny: "tmp_.*"},
+	}})
+
+	tests := []struct {
+		metric string
+		labels []*dto.LabelPair
+		keep   bool
+	}{
+		{"postgresql_tables_seq_tup_read_total", labelPairs("table", "orders_2024"), true},
+		{"postgresql_tables_seq_tup_read_total", labelPairs("table", "customers"), false},
+		{"postgresql_tables_seq_tup_read_total", labelPairs("table", "my_orders_2024"), false},
+		{"postgresql_tables_seq_scan_total", labelPairs("table", "customers"), true},
+		{"postgresql_tables_seq_scan_total", labelPairs("table", "tmp_import"), false},
+		{"postgresql_tables_seq_tup_read_total", labelPairs("table", "tmp_orders"), false},
+		// series without the label are not affected
+		{"postgresql_tables_seq_tup_read_total", labelPairs("db", "app"), true},
+		{"postgresql_up", nil, true},
+	}
+
+	for _, test := range tests {
+		if keep := f.keepSeries(test.metric, test.labels); keep != test.keep {
+			t.Errorf("%s %v: expected %t, got %t", test.metric, test.labels, test.keep, keep)
+		}
+	}
+}
+
+// sendAll returns series sent by target for collection
+func sendAll(tg *target, name string, collect func(chan<- prometheus.Metric)) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		tg.send(ch, name, collect)
+		close(ch)
+	}()
+
+	var out []*dto.Metric
+	for m := range ch {
+		pb := &dto.Metric{}
+		m.Write(pb)
+		out = append(out, pb)
+	}
+
+	return out
+}
+
+func testTarget(filter *metricFilter, labels []*dto.LabelPair) *target {
+	return &target{
+		filter: filter,
+		labels: labels,
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "exporter_series_dropped_total",
+			Help: "Total series dropped because collection exceeded its limit.",
+		}, []string{"collection"}),
+	}
+}
+
+func TestSendLimits(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "postgresql_tables_pages", Help: "h"}, []string{"table"})
+	for _, table := range []string{"a", "b", "c", "d", "e"} {
+		gauge.WithLabelValues(table).Set(1)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     FilterConfig
+		sent    int
+		dropped float64
+	}{
+		{"no limit", FilterConfig{}, 5, 0},
+		{"limit of collection", FilterConfig{Limits: map[string]int{"tables": 2}}, 2, 3},
+		{"limit of other collection", FilterConfig{Limits: map[string]int{"databases": 2}}, 5, 0},
+		{"limit above series count", FilterConfig{Limits: map[string]int{"tables": 10}}, 5, 0},
+		// series dropped by label filter don't count towards limit
+		{"limit after label filter", FilterConfig{
+			Limits: map[string]int{"tables": 2},
+			Labels: []LabelFilterConfig{{Label: "table", Deny: "a|b"}},
+		}, 2, 1},
+		{"denied metric", FilterConfig{Deny: []string{"postgresql_tables_.*"}, Limits: map[string]int{"tables": 2}}, 0, 0},
+	}
+
+	for _, test := range tests {
+		tg := testTarget(newMetricFilter(test.cfg), nil)
+		sent := sendAll(tg, "tables", gauge.Collect)
+		if len(sent) != test.sent {
+			t.Errorf("%s: expected %d series, got %d", test.name, test.sent, len(sent))
+		}
+
+		pb := &dto.Metric{}
+		tg.dropped.WithLabelValues("tables").Write(pb)
+		if dropped := pb.GetCounter().GetValue(); dropped != test.dropped {
+			t.Errorf("%s: expected %v dropped series, got %v", test.name, test.dropped, dropped)
+		}
+	}
+}
+
+func TestSendTargetLabels(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "postgresql_databases_size_bytes", Help: "h"}, []string{"db", "target"})
+	gauge.WithLabelValues("app", "own").Set(1)
+
+	tg := testTarget(nil, labelPairs("env", "prod", "target", "main"))
+	sent := sendAll(tg, "databases", gauge.Collect)
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(sent))
+	}
+
+	// labels of metric take precedence, labels are sorted by name
+	var labels []string
+	for _, label := range sent[0].Label {
+		labels = append(labels, label.GetName()+"="+label.GetValue())
+	}
+	if got := strings.Join(labels, ","); got != "db=app,env=prod,target=own" {
+		t.Fatalf("unexpected labels %s", got)
+	}
+
+	// metric itself is not changed
+	pb := &dto.Metric{}
+	gauge.WithLabelValues("app", "own").Write(pb)
+	if len(pb.Label) != 2 {
+		t.Fatalf("labels of original metric changed: %v", pb.Label)
+	}
+}
+
+func TestKeepCollection(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        FilterConfig
+		collection metrics.Collection
+		keep       bool
+	}{
+		{"no filter", FilterConfig{}, metrics.NewDBMetrics([]string{"app"}, false), true},
+		{"all metrics denied", FilterConfig{Deny: []string{"postgresql_databases_.*"}}, metrics.NewDBMetrics([]string{"app"}, false), false},
+		{"one metric allowed", FilterConfig{Allow: []string{"postgresql_databases_xact_commit_total"}}, metrics.NewDBMetrics([]string{"app"}, false), true},
+		{"other collection allowed", FilterConfig{Allow: []string{"postgresql_up"}}, metrics.NewDBMetrics([]string{"app"}, false), false},
+		{"some metrics denied", FilterConfig{Deny: []string{"postgresql_tablespaces_.*"}}, metrics.NewDiskMetrics(), true},
+		// metrics of the collection are created during scrape
+		{"nothing described", FilterConfig{Allow: []string{"postgresql_up"}}, metrics.NewIOMetrics(), true},
+	}
+
+	for _, test := range tests {
+		f := newMetricFilter(test.cfg)
+		if keep := f.keepCollection(test.collection); keep != test.keep {
+			t.Errorf("%s: expected %t, got %t", test.name, test.keep, keep)
+		}
+	}
+}
+
+func TestDescName(t *testing.T) {
+	desc := prometheus.NewDesc("postgresql_tables_size_bytes", `Help with "quotes"`, []string{"table"}, prometheus.Labels{"env": "prod"})
+
+	// descName relies on Desc.String() starting with the quoted name
+	if s := desc.String(); !strings.HasPrefix(s, `Desc{fqName: "postgresql_tables_size_bytes", `) {
+		t.Fatalf("format of Desc.String() changed: %s", s)
+	}
+	if name := descName(desc); name != "postgresql_tables_size_bytes" {
+		t.Fatalf("expected postgresql_tables_size_bytes, got %q", name)
+	}
+	// the name is cached
+	if name := descName(desc); name != "postgresql_tables_size_bytes" {
+		t.Fatalf("expected cached postgresql_tables_size_bytes, got %q", name)
+	}
+}
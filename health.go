@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/log"
+)
+
+type targetStatus struct {
+	Name  string `json:"name"`
+	Up    bool   `json:"up"`
+	Error string `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Ready   bool           `json:"ready"`
+	Targets []targetStatus `json:"targets"`
+}
+
+// healthzHandler confirms that the process is serving requests
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// readyHandler checks connection to each target without running collectors
+func readyHandler(targets []*target, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]chan targetStatus, len(targets))
+		for i, t := range targets {
+			statuses[i] = make(chan targetStatus, 1)
+			go func(t *target, ch chan<- targetStatus) {
+				status := targetStatus{Name: t.name, Up: true}
+				if err := pingWithTimeout(t, timeout); err != nil {
+					status.Up = false
+					status.Error = redact(err.Error())
+				}
+				ch <- status
+			}(t, statuses[i])
+		}
+
+		result := readiness{Ready: true}
+		for _, ch := range statuses {
+			status := <-ch
+			result.Ready = result.Ready && status.Up
+			result.Targets = append(result.Targets, status)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Errorf("error writing readiness response: %s", err)
+		}
+	}
+}
+
+// pingWithTimeout cancels the ping query on timeout, so hung checks don't
+// hold pool connections
+func pingWithTimeout(t *target, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := t.ping(ctx)
+	if err != nil && ctx.Err() != nil {
+		return errors.New("timeout after " + timeout.String())
+	}
+
+	return err
+}
@@ -3,30 +3,48 @@ package metrics
 import (
 	"database/sql"
 	"errors"
+	"math"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-const (
-	namespace = "postgresql"
-)
+// namespace is a prefix of all metric names
+var namespace = "postgresql"
+
+// SetNamespace changes prefix of metric names, it must be called before
+// collections are created.
+func SetNamespace(ns string) {
+	namespace = ns
+}
 
 type metric struct {
 	Name string
 	Help string
 }
 
+// DBConnector returns connections pool of database of the same server, it is
+// used by collections reading catalogs, which are local to database
+type DBConnector func(dbName string) *sql.DB
+
 type Collection interface {
 	Scrape(*sql.DB) error
 	Collect(chan<- prometheus.Metric)
 	Describe(chan<- *prometheus.Desc)
 }
 
-func getMetrics(db *sql.DB, metricsDef map[string]metric, tail string, args []interface{}) (map[string]float64, error) {
-	var keys []string
-	for key := range metricsDef {
-		keys = append(keys, key)
+// Independent is a collection which needs no database, e.g. it reads files
+// of the server, so it is scraped and collected regardless of connection state
+type Independent interface {
+	Collection
+	Independent()
+}
+
+func getMetrics(db *sql.DB, subsystem string, metricsDef map[string]metric, tail string, args []interface{}) (map[string]float64, error) {
+	keys := wantedColumns(subsystem, metricsDef)
+	if len(keys) == 0 {
+		// all metrics are filtered out
+		return nil, nil
 	}
 
 	vals := make([]interface{}, len(keys))
@@ -46,3 +64,41 @@ func getMetrics(db *sql.DB, metricsDef map[string]metric, tail string, args []in
 
 	return result, nil
 }
+
+// serverVersion returns server version as a number, e.g. 90605 for 9.6.5 or 100001 for 10.1
+func serverVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SHOW server_version_num").Scan(&version)
+	if err != nil {
+		return 0, errors.New("error getting server version: " + err.Error())
+	}
+
+	return version, nil
+}
+
+// blockCounters are cumulative numbers of blocks found in cache and read
+type blockCounters struct {
+	hit, read float64
+}
+
+// ratio returns percent of blocks found in cache rounded to hundredths
+func (b blockCounters) ratio() float64 {
+	if b.hit+b.read == 0 {
+		return 0
+	}
+
+	return math.Round(b.hit*10000/(b.hit+b.read)) / 100
+}
+
+// setWindowedRatio sets cache hit ratio of blocks accessed between scrapes.
+// There is no value when nothing was accessed, after first scrape or after
+// statistics reset.
+func setWindowedRatio(gauge *prometheus.GaugeVec, label string, prev, cur blockCounters) {
+	delta := blockCounters{hit: cur.hit - prev.hit, read: cur.read - prev.read}
+	if prev == (blockCounters{}) || delta.hit < 0 || delta.read < 0 || delta.hit+delta.read == 0 {
+		gauge.DeleteLabelValues(label)
+		return
+	}
+
+	gauge.WithLabelValues(label).Set(delta.ratio())
+}
@@ -0,0 +1,275 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Statistics based estimations, see https://github.com/ioguix/pgsql-bloat-estimation
+const (
+	tableBloatQuery = `SELECT tblname,
+		CASE WHEN tblpages > 0 AND tblpages - est_tblpages_ff > 0 THEN (tblpages - est_tblpages_ff) * bs ELSE 0 END AS bloat_size,
+		CASE WHEN tblpages > 0 AND tblpages - est_tblpages_ff > 0 THEN (tblpages - est_tblpages_ff)::float / tblpages ELSE 0 END AS bloat_ratio
+	FROM (
+		SELECT ceil(reltuples / ((bs - page_hdr) * fillfactor / (tpl_size * 100))) + ceil(toasttuples / 4) AS est_tblpages_ff,
+			tblpages, bs, tblname
+		FROM (
+			SELECT (4 + tpl_hdr_size + tpl_data_size + (2 * ma)
+					- CASE WHEN tpl_hdr_size % ma = 0 THEN ma ELSE tpl_hdr_size % ma END
+					- CASE WHEN ceil(tpl_data_size)::int % ma = 0 THEN ma ELSE ceil(tpl_data_size)::int % ma END
+				) AS tpl_size,
+				(heappages + toastpages) AS tblpages, reltuples, toasttuples, bs, page_hdr, tblname, fillfactor
+			FROM (
+				SELECT tbl.oid AS tblid, tbl.relname AS tblname, tbl.reltuples,
+					tbl.relpages AS heappages, coalesce(toast.relpages, 0) AS toastpages,
+					coalesce(toast.reltuples, 0) AS toasttuples,
+					coalesce(substring(array_to_string(tbl.reloptions, ' ') FROM 'fillfactor=([0-9]+)')::smallint, 100) AS fillfactor,
+					current_setting('block_size')::numeric AS bs,
+					CASE WHEN version() ~ 'mingw32' OR version() ~ '64-bit|x86_64|ppc64|ia64|amd64' THEN 8 ELSE 4 END AS ma,
+					24 AS page_hdr,
+					23 + CASE WHEN max(coalesce(s.null_frac, 0)) > 0 THEN (7 + count(s.attname)) / 8 ELSE 0::int END
+						+ CASE WHEN bool_or(att.attname = 'oid' AND att.attnum < 0) THEN 4 ELSE 0 END AS tpl_hdr_size,
+					sum((1 - coalesce(s.null_frac, 0)) * coalesce(s.avg_width, 0)) AS tpl_data_size
+				FROM pg_attribute AS att
+					JOIN pg_class AS tbl ON att.attrelid = tbl.oid
+					JOIN pg_namespace AS ns ON ns.oid = tbl.relnamespace
+					LEFT JOIN pg_stats AS s ON s.schemaname = ns.nspname
+						AND s.tablename = tbl.relname AND s.inherited = false AND s.attname = att.attname
+					LEFT JOIN pg_class AS toast ON tbl.reltoastrelid = toast.oid
+				WHERE NOT att.attisdropped AND tbl.relkind IN ('r', 'm') AND ns.nspname = 'public'
+				GROUP BY 1, 2, 3, 4, 5, 6, 7, 8, 9, 10
+			) AS s
+		) AS s2
+	) AS s3`
+
+	indexBloatQuery = `SELECT tblname, idxname,
+		CASE WHEN relpages > est_pages_ff THEN bs * (relpages - est_pages_ff) ELSE 0 END AS bloat_size,
+		CASE WHEN relpages > est_pages_ff THEN (relpages - est_pages_ff)::float / relpages ELSE 0 END AS bloat_ratio
+	FROM (
+		SELECT coalesce(1 + ceil(reltuples / floor((bs - pageopqdata - pagehdr) * fillfactor / (100 * (4 + nulldatahdrwidth)::float))), 0) AS est_pages_ff,
+			bs, tblname, idxname, relpages
+		FROM (
+			SELECT bs, tblname, idxname, reltuples, relpages, fillfactor, pagehdr, pageopqdata,
+				(index_tuple_hdr_bm + maxalign
+					- CASE WHEN index_tuple_hdr_bm % maxalign = 0 THEN maxalign ELSE index_tuple_hdr_bm % maxalign END
+					+ nulldatawidth + maxalign
+					- CASE WHEN nulldatawidth = 0 THEN 0 WHEN nulldatawidth::integer % maxalign = 0 THEN maxalign ELSE nulldatawidth::integer % maxalign END
+				)::numeric AS nulldatahdrwidth
+			FROM (
+				SELECT i.tblname, i.idxname, i.reltuples, i.relpages, i.fillfactor,
+					current_setting('block_size')::numeric AS bs,
+					CASE WHEN version() ~ 'mingw32' OR version() ~ '64-bit|x86_64|ppc64|ia64|amd64' THEN 8 ELSE 4 END AS maxalign,
+					24 AS pagehdr,
+					16 AS pageopqdata,
+					CASE WHEN max(coalesce(s.null_frac, 0)) = 0 THEN 8 ELSE 8 + ((32 + 8 - 1) / 8) END AS index_tuple_hdr_bm,
+					sum((1 - coalesce(s.null_frac, 0)) * coalesce(s.avg_width, 1024)) AS nulldatawidth
+				FROM (
+					SELECT ct.relname AS tblname, ct.relnamespace, ic.idxname, ic.reltuples, ic.relpages, ic.fillfactor,
+						coalesce(a1.attname, a2.attname) AS attname,
+						CASE WHEN a1.attnum IS NULL THEN ic.idxname ELSE ct.relname END AS attrelname
+					FROM (
+						SELECT idxname, reltuples, relpages, tbloid, idxoid, fillfactor, indkey,
+							generate_series(1, indnatts) AS attpos
+						FROM (
+							SELECT ci.relname AS idxname, ci.reltuples, ci.relpages, i.indrelid AS tbloid,
+								i.indexrelid AS idxoid, i.indnatts,
+								coalesce(substring(array_to_string(ci.reloptions, ' ') FROM 'fillfactor=([0-9]+)')::smallint, 90) AS fillfactor,
+								string_to_array(textin(int2vectorout(i.indkey)), ' ')::int[] AS indkey
+							FROM pg_index i
+								JOIN pg_class ci ON ci.oid = i.indexrelid
+							WHERE ci.relam = (SELECT oid FROM pg_am WHERE amname = 'btree') AND ci.relpages > 0
+						) AS idx_data
+					) AS ic
+						JOIN pg_class ct ON ct.oid = ic.tbloid
+						LEFT JOIN pg_attribute a1 ON ic.indkey[ic.attpos] <> 0
+							AND a1.attrelid = ic.tbloid AND a1.attnum = ic.indkey[ic.attpos]
+						LEFT JOIN pg_attribute a2 ON ic.indkey[ic.attpos] = 0
+							AND a2.attrelid = ic.idxoid AND a2.attnum = ic.attpos
+				) i
+					JOIN pg_namespace n ON n.oid = i.relnamespace
+					JOIN pg_stats s ON s.schemaname = n.nspname AND s.tablename = i.attrelname AND s.attname = i.attname
+				WHERE n.nspname = 'public'
+				GROUP BY 1, 2, 3, 4, 5
+			) AS rows_data_stats
+		) AS rows_hdr_pdg_stats
+	) AS relation_stats`
+
+	// pgstattuple_approx works only for tables and materialized views, the
+	// same relations are estimated by tableBloatQuery, so others get no rows
+	exactTableBloatQuery = `SELECT s.dead_tuple_len + s.approx_free_space,
+		CASE WHEN s.table_len > 0 THEN (s.dead_tuple_len + s.approx_free_space)::float / s.table_len ELSE 0 END
+	FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace,
+		LATERAL pgstattuple_approx(c.oid::regclass) s
+	WHERE n.nspname = 'public' AND c.relname = $1 AND c.relkind IN ('r', 'm')`
+)
+
+// BloatMetrics estimates heap and B-tree index bloat for the tables
+// selected for TableMetrics. Queries are heavy, so they run at most once
+// per refresh interval and previous values are exported in between.
+type BloatMetrics struct {
+	mutex      sync.Mutex
+	tables     *tableSelection
+	exact      bool
+	interval   time.Duration
+	lastScrape time.Time
+	metrics    map[string]*prometheus.GaugeVec
+}
+
+func NewBloatMetrics(t *TableMetrics, exact bool, interval time.Duration) *BloatMetrics {
+	return &BloatMetrics{
+		tables:   t.tables,
+		exact:    exact,
+		interval: interval,
+		metrics: map[string]*prometheus.GaugeVec{
+			"table_wasted": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "bloat",
+				Name:      "table_wasted_bytes",
+				Help:      "Estimated wasted space in table",
+			}, []string{"table"}),
+			"table_ratio": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "bloat",
+				Name:      "table_ratio",
+				Help:      "Estimated ratio of wasted space to table size",
+			}, []string{"table"}),
+			"index_wasted": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "bloat",
+				Name:      "index_wasted_bytes",
+				Help:      "Estimated wasted space in B-tree index",
+			}, []string{"table", "index"}),
+			"index_ratio": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "bloat",
+				Name:      "index_ratio",
+				Help:      "Estimated ratio of wasted space to B-tree index size",
+			}, []string{"table", "index"}),
+		},
+	}
+}
+
+func (b *BloatMetrics) Scrape(db *sql.DB) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if time.Since(b.lastScrape) < b.interval {
+		return nil
+	}
+	// failed attempts count too, so heavy queries don't run on every scrape
+	b.lastScrape = time.Now()
+
+	if err := b.tables.resolve(db); err != nil {
+		return errors.New("error getting tables list: " + err.Error())
+	}
+
+	exact := false
+	if b.exact {
+		err := db.QueryRow("SELECT count(*) > 0 FROM pg_extension WHERE extname = 'pgstattuple'").Scan(&exact)
+		if err != nil {
+			return errors.New("error checking pgstattuple extension: " + err.Error())
+		}
+	}
+
+	var err error
+	if exact {
+		err = b.getExactTableBloat(db)
+	} else {
+		err = b.getTableBloat(db)
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.getIndexBloat(db)
+}
+
+func (b *BloatMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range b.metrics {
+		m.Describe(ch)
+	}
+}
+
+func (b *BloatMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range b.metrics {
+		m.Collect(ch)
+	}
+}
+
+func (b *BloatMetrics) getTableBloat(db *sql.DB) error {
+	rows, err := db.Query(tableBloatQuery)
+	if err != nil {
+		return errors.New("error running table bloat query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var wasted, ratio float64
+		err = rows.Scan(&name, &wasted, &ratio)
+		if err != nil {
+			return errors.New("error running table bloat query on database: " + err.Error())
+		}
+
+		if !b.tables.contains(name) {
+			// process only selected tables
+			continue
+		}
+		b.metrics["table_wasted"].WithLabelValues(name).Set(wasted)
+		b.metrics["table_ratio"].WithLabelValues(name).Set(ratio)
+	}
+
+	return rows.Err()
+}
+
+func (b *BloatMetrics) getExactTableBloat(db *sql.DB) error {
+	for _, name := range b.tables.names {
+		var wasted, ratio float64
+		err := db.QueryRow(exactTableBloatQuery, name).Scan(&wasted, &ratio)
+		if err == sql.ErrNoRows {
+			// view, foreign or partitioned table has no heap of its own
+			continue
+		}
+		if err != nil {
+			return errors.New("error running pgstattuple_approx on table " + name + ": " + err.Error())
+		}
+		b.metrics["table_wasted"].WithLabelValues(name).Set(wasted)
+		b.metrics["table_ratio"].WithLabelValues(name).Set(ratio)
+	}
+
+	return nil
+}
+
+func (b *BloatMetrics) getIndexBloat(db *sql.DB) error {
+	rows, err := db.Query(indexBloatQuery)
+	if err != nil {
+		return errors.New("error running index bloat query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, index string
+		var wasted, ratio float64
+		err = rows.Scan(&table, &index, &wasted, &ratio)
+		if err != nil {
+			return errors.New("error running index bloat query on database: " + err.Error())
+		}
+
+		if !b.tables.contains(table) {
+			// process only selected tables
+			continue
+		}
+		b.metrics["index_wasted"].WithLabelValues(table, index).Set(wasted)
+		b.metrics["index_ratio"].WithLabelValues(table, index).Set(ratio)
+	}
+
+	return rows.Err()
+}
+
+// check interface
+var _ Collection = new(BloatMetrics)
@@ -34,7 +34,7 @@ func (b *BufferMetrics) Scrape(db *sql.DB) error {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	result, err := getMetrics(db, bufferMetrics, "pg_stat_bgwriter", nil)
+	result, err := getMetrics(db, "buffers", bufferMetrics, "pg_stat_bgwriter", nil)
 	if err != nil {
 		return errors.New("error running table stats query on database: " + err.Error())
 	}
@@ -76,6 +76,10 @@ func (c *CustomQuery) initMetric(rows *sql.Rows) error {
 }
 
 func (c *CustomQuery) scrape(db *sql.DB) error {
+	if !wanted("custom", c.Name) {
+		return nil
+	}
+
 	rows, err := db.Query(c.Query)
 	if err != nil {
 		return err
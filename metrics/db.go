@@ -21,6 +21,8 @@ var (
 		"deadlocks":     metric{Name: "deadlocks_total", Help: "Number of deadlocks detected in this database"},
 		"temp_files":    metric{Name: "temp_files_total", Help: "Number of temporary files created by queries in this database"},
 		"temp_bytes":    metric{Name: "temp_bytes", Help: "Total amount of data written to temporary files by queries in this database"},
+		"blks_hit":      metric{Name: "blks_hit_total", Help: "Number of times disk blocks were found already in the buffer cache"},
+		"blks_read":     metric{Name: "blks_read_total", Help: "Number of disk blocks read in this database"},
 	}
 )
 
@@ -28,9 +30,13 @@ type DBMetrics struct {
 	mutex   sync.Mutex
 	names   []string
 	metrics map[string]*prometheus.GaugeVec
+	// blocks of previous scrape by database for windowed cache hit ratio
+	blocks map[string]blockCounters
 }
 
-func NewDBMetrics(dbNames []string) *DBMetrics {
+// NewDBMetrics creates database collection, with windowedRatio cache hit
+// ratio is also computed from blocks accessed between scrapes
+func NewDBMetrics(dbNames []string, windowedRatio bool) *DBMetrics {
 	d := &DBMetrics{
 		names: dbNames,
 		metrics: map[string]*prometheus.GaugeVec{
@@ -49,6 +55,26 @@ func NewDBMetrics(dbNames []string) *DBMetrics {
 		},
 	}
 
+	// all metrics are created beforehand, so the filter knows them
+	for key, m := range dbMetrics {
+		d.metrics[key] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "databases",
+			Name:      m.Name,
+			Help:      m.Help,
+		}, []string{"db"})
+	}
+
+	if windowedRatio {
+		d.blocks = make(map[string]blockCounters)
+		d.metrics["windowed_cache_hit_ratio"] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "databases",
+			Name:      "windowed_cache_hit_ratio_percents",
+			Help:      "Cache hit ratio of blocks accessed since previous scrape",
+		}, []string{"db"})
+	}
+
 	return d
 }
 
@@ -64,31 +90,40 @@ func (d *DBMetrics) Scrape(db *sql.DB) error {
 		}
 		d.metrics["size"].WithLabelValues(name).Set(*size)
 
-		results, err := getMetrics(db, dbMetrics, "pg_stat_database WHERE datname = $1", []interface{}{name})
+		results, err := getMetrics(db, "databases", dbMetrics, "pg_stat_database WHERE datname = $1", []interface{}{name})
 		if err != nil {
 			return errors.New("error running database stats query on database: " + err.Error())
 		}
 
 		for key, val := range results {
-			if _, ok := d.metrics[key]; !ok {
-				d.metrics[key] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-					Namespace: namespace,
-					Subsystem: "databases",
-					Name:      dbMetrics[key].Name,
-					Help:      dbMetrics[key].Help,
-				}, []string{"db"})
-			}
-
 			d.metrics[key].WithLabelValues(name).Set(val)
 		}
 
-		cacheRatio := new(float64)
-		query := "SELECT round(blks_hit*100/(blks_hit+blks_read), 2) AS cache_hit_ratio FROM pg_stat_database WHERE datname = $1 and blks_read > 0 union all select 0.00 AS cache_hit_ratio ORDER BY cache_hit_ratio DESC limit 1"
-		err = db.QueryRow(query, name).Scan(cacheRatio)
+		err = d.getCacheRatio(db, name)
 		if err != nil {
-			return errors.New("failed to get database cache hit ratio: " + err.Error())
+			return err
 		}
-		d.metrics["cache_hit_ratio"].WithLabelValues(name).Set(*cacheRatio)
+	}
+
+	return nil
+}
+
+func (d *DBMetrics) getCacheRatio(db *sql.DB, name string) error {
+	windowed := d.blocks != nil && wanted("databases", "windowed_cache_hit_ratio_percents")
+	if !windowed && !wanted("databases", "cache_hit_ratio_percents") {
+		return nil
+	}
+
+	var blocks blockCounters
+	err := db.QueryRow("SELECT blks_hit, blks_read FROM pg_stat_database WHERE datname = $1", name).Scan(&blocks.hit, &blocks.read)
+	if err != nil {
+		return errors.New("failed to get database cache hit ratio: " + err.Error())
+	}
+	d.metrics["cache_hit_ratio"].WithLabelValues(name).Set(blocks.ratio())
+
+	if windowed {
+		setWindowedRatio(d.metrics["windowed_cache_hit_ratio"], name, d.blocks[name], blocks)
+		d.blocks[name] = blocks
 	}
 
 	return nil
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+type diskUsage struct {
+	files      metric
+	size       metric
+	minVersion int
+	// function must be executable to run query
+	function string
+	// query must return files count and total size
+	query string
+}
+
+var diskUsages = []diskUsage{
+	{
+		files:      metric{Name: "wal_files", Help: "Number of files in WAL directory"},
+		size:       metric{Name: "wal_size_bytes", Help: "Total size of files in WAL directory"},
+		minVersion: 100000,
+		function:   "pg_ls_waldir()",
+		query:      "SELECT count(*), coalesce(sum(size), 0) FROM pg_ls_waldir()",
+	},
+	{
+		files:      metric{Name: "temp_files", Help: "Number of temporary files in default tablespace"},
+		size:       metric{Name: "temp_size_bytes", Help: "Total size of temporary files in default tablespace"},
+		minVersion: 120000,
+		function:   "pg_ls_tmpdir()",
+		query:      "SELECT count(*), coalesce(sum(size), 0) FROM pg_ls_tmpdir()",
+	},
+	{
+		files:      metric{Name: "archive_ready_files", Help: "Number of WAL segments waiting to be archived"},
+		size:       metric{Name: "archive_ready_size_bytes", Help: "Total size of WAL segments waiting to be archived"},
+		minVersion: 120000,
+		function:   "pg_ls_archive_statusdir()",
+		// status files are empty, size of segments is computed from their count
+		query: "SELECT count(*), count(*) * pg_size_bytes(current_setting('wal_segment_size')) " +
+			"FROM pg_ls_archive_statusdir() WHERE name LIKE '%.ready'",
+	},
+}
+
+// DiskMetrics reports on-disk footprint of tablespaces, WAL, temporary
+// files and WAL segments waiting to be archived.
+type DiskMetrics struct {
+	mutex  sync.Mutex
+	warned bool
+	// metrics are vectors without labels, so they aren't exported until
+	// set, as not all of them are available in older versions
+	metrics     map[string]*prometheus.GaugeVec
+	tablespaces map[string]*prometheus.GaugeVec
+}
+
+func NewDiskMetrics() *DiskMetrics {
+	d := &DiskMetrics{
+		metrics: map[string]*prometheus.GaugeVec{},
+		tablespaces: map[string]*prometheus.GaugeVec{
+			"size": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "tablespaces",
+				Name:      "size_bytes",
+				Help:      "Size of tablespace",
+			}, []string{"tablespace"}),
+			"info": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "tablespaces",
+				Name:      "info",
+				Help:      "Tablespace location, empty for built-in tablespaces",
+			}, []string{"tablespace", "location"}),
+		},
+	}
+
+	for _, usage := range diskUsages {
+		for _, m := range []metric{usage.files, usage.size} {
+			d.metrics[m.Name] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "disk",
+				Name:      m.Name,
+				Help:      m.Help,
+			}, nil)
+		}
+	}
+
+	return d
+}
+
+func (d *DiskMetrics) Scrape(db *sql.DB) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+
+	// functions need superuser or pg_monitor, the rest is collected without them
+	var skipped []string
+	hidden, err := d.getTablespaces(db, version)
+	if err != nil {
+		return errors.New("error running tablespaces query on database: " + err.Error())
+	}
+	if hidden {
+		skipped = append(skipped, "pg_tablespace_size()")
+	}
+
+	for _, usage := range diskUsages {
+		if version < usage.minVersion {
+			continue
+		}
+
+		var allowed bool
+		err = db.QueryRow("SELECT has_function_privilege($1, 'EXECUTE')", usage.function).Scan(&allowed)
+		if err != nil {
+			return errors.New("error checking " + usage.function + " privilege: " + err.Error())
+		}
+		if !allowed {
+			skipped = append(skipped, usage.function)
+			continue
+		}
+
+		var files, size float64
+		err = db.QueryRow(usage.query).Scan(&files, &size)
+		if err != nil {
+			return errors.New("error getting " + usage.files.Name + ": " + err.Error())
+		}
+		d.metrics[usage.files.Name].WithLabelValues().Set(files)
+		d.metrics[usage.size.Name].WithLabelValues().Set(size)
+	}
+
+	if len(skipped) > 0 && !d.warned {
+		log.Warnf("%s are not allowed for some tablespaces or at all, their disk usage is not collected", strings.Join(skipped, ", "))
+	}
+	d.warned = len(skipped) > 0
+
+	return nil
+}
+
+// getTablespaces reads tablespaces and sizes of those allowed to be read,
+// it tells whether some sizes are hidden
+func (d *DiskMetrics) getTablespaces(db *sql.DB, version int) (bool, error) {
+	location := "''"
+	if version >= 90200 {
+		location = "pg_tablespace_location(oid)"
+	}
+	// size of default tablespace of current database is always allowed
+	allowed := "has_tablespace_privilege(oid, 'CREATE') OR oid = (SELECT dattablespace FROM pg_database WHERE datname = current_database())"
+	if version >= 100000 {
+		allowed += " OR pg_has_role('pg_read_all_stats', 'USAGE')"
+	}
+
+	rows, err := db.Query("SELECT spcname, " + location + ", CASE WHEN " + allowed + " THEN pg_tablespace_size(oid) END FROM pg_tablespace")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	hidden := false
+	d.tablespaces["size"].Reset()
+	d.tablespaces["info"].Reset()
+	for rows.Next() {
+		var name, location string
+		var size sql.NullFloat64
+		err = rows.Scan(&name, &location, &size)
+		if err != nil {
+			return false, err
+		}
+		if size.Valid {
+			d.tablespaces["size"].WithLabelValues(name).Set(size.Float64)
+		} else {
+			hidden = true
+		}
+		d.tablespaces["info"].WithLabelValues(name, location).Set(1)
+	}
+
+	return hidden, rows.Err()
+}
+
+func (d *DiskMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range d.metrics {
+		m.Describe(ch)
+	}
+	for _, m := range d.tablespaces {
+		m.Describe(ch)
+	}
+}
+
+func (d *DiskMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range d.metrics {
+		m.Collect(ch)
+	}
+	for _, m := range d.tablespaces {
+		m.Collect(ch)
+	}
+}
+
+// check interface
+var _ Collection = new(DiskMetrics)
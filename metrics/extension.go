@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const extensionsQuery = `SELECT e.extname, e.extversion, n.nspname, coalesce(a.default_version, '')
+	FROM pg_extension e
+	JOIN pg_namespace n ON n.oid = e.extnamespace
+	LEFT JOIN pg_available_extensions a ON a.name = e.extname`
+
+// ExtensionMetrics reports extensions installed in monitored databases and
+// whether newer versions are available, i.e. ALTER EXTENSION UPDATE is missing
+type ExtensionMetrics struct {
+	mutex   sync.Mutex
+	names   []string
+	connect DBConnector
+	metrics map[string]*prometheus.GaugeVec
+}
+
+func NewExtensionMetrics(dbNames []string, connect DBConnector) *ExtensionMetrics {
+	return &ExtensionMetrics{
+		names:   dbNames,
+		connect: connect,
+		metrics: map[string]*prometheus.GaugeVec{
+			"info": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "extensions",
+				Name:      "info",
+				Help:      "Installed extension with its version, schema and default version available on server",
+			}, []string{"db", "extension", "version", "schema", "default_version"}),
+			"outdated": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "extensions",
+				Name:      "outdated",
+				Help:      "Whether installed version of extension differs from default version available on server",
+			}, []string{"db", "extension"}),
+		},
+	}
+}
+
+func (e *ExtensionMetrics) Scrape(db *sql.DB) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, m := range e.metrics {
+		m.Reset()
+	}
+
+	for _, name := range e.names {
+		err := e.getExtensions(e.connect(name), name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *ExtensionMetrics) getExtensions(db *sql.DB, dbName string) error {
+	rows, err := db.Query(extensionsQuery)
+	if err != nil {
+		return errors.New("error running extensions query on database " + dbName + ": " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, version, schema, defaultVersion string
+		err = rows.Scan(&name, &version, &schema, &defaultVersion)
+		if err != nil {
+			return errors.New("error running extensions query on database " + dbName + ": " + err.Error())
+		}
+
+		e.metrics["info"].WithLabelValues(dbName, name, version, schema, defaultVersion).Set(1)
+
+		outdated := 0.0
+		if defaultVersion != "" && defaultVersion != version {
+			outdated = 1
+		}
+		e.metrics["outdated"].WithLabelValues(dbName, name).Set(outdated)
+	}
+
+	return rows.Err()
+}
+
+func (e *ExtensionMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range e.metrics {
+		m.Describe(ch)
+	}
+}
+
+func (e *ExtensionMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range e.metrics {
+		m.Collect(ch)
+	}
+}
+
+// check interface
+var _ Collection = new(ExtensionMetrics)
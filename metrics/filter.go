@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// keepMetric tells whether metric with full name is exported at all
+var keepMetric = func(string) bool { return true }
+
+// SetMetricFilter sets function deciding by full metric name whether metric
+// is exported. Collections don't query columns of dropped metrics and skip
+// queries which give only dropped metrics.
+func SetMetricFilter(keep func(name string) bool) {
+	keepMetric = keep
+}
+
+// wanted tells whether metric of subsystem is exported
+func wanted(subsystem, name string) bool {
+	return keepMetric(prometheus.BuildFQName(namespace, subsystem, name))
+}
+
+// wantedColumns returns columns of definitions which metrics are exported
+func wantedColumns(subsystem string, metricsDef map[string]metric) []string {
+	var keys []string
+	for key, def := range metricsDef {
+		if wanted(subsystem, def.Name) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is a database driver recording queries, which all fail
+type recorder struct {
+	mutex   sync.Mutex
+	queries []string
+}
+
+var recorded = &recorder{}
+
+func init() {
+	sql.Register("recorder", recorded)
+}
+
+func (r *recorder) Open(string) (driver.Conn, error) { return recorderConn{r}, nil }
+
+// take returns recorded queries and forgets them
+func (r *recorder) take() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	queries := r.queries
+	r.queries = nil
+
+	return queries
+}
+
+type recorderConn struct{ r *recorder }
+
+func (c recorderConn) Prepare(query string) (driver.Stmt, error) {
+	c.r.mutex.Lock()
+	c.r.queries = append(c.r.queries, query)
+	c.r.mutex.Unlock()
+
+	return nil, errors.New("query recorded")
+}
+func (c recorderConn) Close() error              { return nil }
+func (c recorderConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+// setMetricFilter sets filter denying metrics for the test
+func setMetricFilter(t *testing.T, deny ...string) {
+	SetMetricFilter(func(name string) bool {
+		for _, d := range deny {
+			if name == d {
+				return false
+			}
+		}
+		return true
+	})
+	t.Cleanup(func() {
+		SetMetricFilter(func(string) bool { return true })
+	})
+}
+
+func TestWantedColumns(t *testing.T) {
+	defs := map[string]metric{
+		"buffers_clean":    {Name: "buffers_clean_total", Help: "h"},
+		"buffers_backend":  {Name: "buffers_backend_total", Help: "h"},
+		"maxwritten_clean": {Name: "maxwritten_clean_total", Help: "h"},
+	}
+
+	tests := []struct {
+		name    string
+		deny    []string
+		columns []string
+	}{
+		{"no filter", nil, []string{"buffers_backend", "buffers_clean", "maxwritten_clean"}},
+		{"one dropped", []string{"postgresql_buffers_buffers_clean_total"}, []string{"buffers_backend", "maxwritten_clean"}},
+		{"other subsystem dropped", []string{"postgresql_tables_buffers_clean_total"}, []string{"buffers_backend", "buffers_clean", "maxwritten_clean"}},
+		{"all dropped", []string{"postgresql_buffers_buffers_clean_total", "postgresql_buffers_buffers_backend_total", "postgresql_buffers_maxwritten_clean_total"}, nil},
+	}
+
+	db, err := sql.Open("recorder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setMetricFilter(t, test.deny...)
+
+			columns := wantedColumns("buffers", defs)
+			sort.Strings(columns)
+			if !reflect.DeepEqual(columns, test.columns) {
+				t.Fatalf("expected columns %v, got %v", test.columns, columns)
+			}
+
+			// dropped columns are not queried, nothing is queried without columns
+			recorded.take()
+			getMetrics(db, "buffers", defs, "pg_stat_bgwriter", nil)
+			queries := recorded.take()
+			if len(test.columns) == 0 {
+				if len(queries) != 0 {
+					t.Fatalf("expected no queries, got %v", queries)
+				}
+				return
+			}
+			if len(queries) != 1 {
+				t.Fatalf("expected one query, got %v", queries)
+			}
+			queried := strings.Split(strings.TrimSuffix(strings.TrimPrefix(queries[0], "SELECT "), " FROM pg_stat_bgwriter"), ",")
+			sort.Strings(queried)
+			if !reflect.DeepEqual(queried, test.columns) {
+				t.Fatalf("expected columns %v in query, got %q", test.columns, queries[0])
+			}
+		})
+	}
+}
+
+func TestSkipDroppedQueries(t *testing.T) {
+	db, err := sql.Open("recorder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	setMetricFilter(t, "postgresql_custom_orders_count")
+	recorded.take()
+
+	c := &CustomQuery{Name: "orders_count", Help: "h", Query: "SELECT count(*) FROM orders"}
+	if err := c.scrape(db); err != nil {
+		t.Fatal(err)
+	}
+	if queries := recorded.take(); len(queries) != 0 {
+		t.Fatalf("query of dropped custom metric ran: %v", queries)
+	}
+
+	c = &CustomQuery{Name: "users_count", Help: "h", Query: "SELECT count(*) FROM users"}
+	c.scrape(db)
+	if queries := recorded.take(); len(queries) != 1 || queries[0] != c.Query {
+		t.Fatalf("expected query of custom metric, got %v", queries)
+	}
+}
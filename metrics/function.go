@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// FunctionMetrics exports pg_stat_user_functions. Functions are matched
+// against include and exclude patterns as "schema.function", overloaded
+// functions are summed up.
+type FunctionMetrics struct {
+	mutex   sync.Mutex
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	limit   int
+	warned  bool
+	metrics map[string]*prometheus.GaugeVec
+	tracked prometheus.Gauge
+}
+
+// NewFunctionMetrics creates function stats collection. Nil patterns match
+// everything, limit keeps only top N functions by total time, 0 means no limit.
+func NewFunctionMetrics(include, exclude *regexp.Regexp, limit int) *FunctionMetrics {
+	labels := []string{"schema", "function"}
+
+	return &FunctionMetrics{
+		include: include,
+		exclude: exclude,
+		limit:   limit,
+		metrics: map[string]*prometheus.GaugeVec{
+			"calls": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "functions",
+				Name:      "calls_total",
+				Help:      "Number of times this function has been called",
+			}, labels),
+			"total_time": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "functions",
+				Name:      "total_time_seconds_total",
+				Help:      "Total time spent in this function and all other functions called by it",
+			}, labels),
+			"self_time": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "functions",
+				Name:      "self_time_seconds_total",
+				Help:      "Total time spent in this function itself, not including other functions called by it",
+			}, labels),
+		},
+		tracked: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "functions",
+			Name:      "tracking_enabled",
+			Help:      "Whether function call statistics are collected, i.e. track_functions is not none",
+		}),
+	}
+}
+
+func (f *FunctionMetrics) Scrape(db *sql.DB) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var track string
+	err := db.QueryRow("SHOW track_functions").Scan(&track)
+	if err != nil {
+		return errors.New("error getting track_functions setting: " + err.Error())
+	}
+	if track == "none" {
+		if !f.warned {
+			log.Warn("track_functions is set to none, function stats are not collected")
+			f.warned = true
+		}
+		f.tracked.Set(0)
+	} else {
+		f.warned = false
+		f.tracked.Set(1)
+	}
+
+	rows, err := db.Query(`SELECT schemaname, funcname, sum(calls), sum(total_time), sum(self_time)
+		FROM pg_stat_user_functions
+		GROUP BY schemaname, funcname
+		ORDER BY sum(total_time) DESC`)
+	if err != nil {
+		return errors.New("error running function stats query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	// the set of top functions changes over time
+	for _, m := range f.metrics {
+		m.Reset()
+	}
+
+	count := 0
+	for rows.Next() {
+		var schema, name string
+		var calls, totalTime, selfTime float64
+		err = rows.Scan(&schema, &name, &calls, &totalTime, &selfTime)
+		if err != nil {
+			return errors.New("error running function stats query on database: " + err.Error())
+		}
+
+		fullName := schema + "." + name
+		if f.include != nil && !f.include.MatchString(fullName) {
+			continue
+		}
+		if f.exclude != nil && f.exclude.MatchString(fullName) {
+			continue
+		}
+		if f.limit > 0 && count >= f.limit {
+			break
+		}
+		count++
+
+		// times are reported in milliseconds
+		f.metrics["calls"].WithLabelValues(schema, name).Set(calls)
+		f.metrics["total_time"].WithLabelValues(schema, name).Set(totalTime / 1000)
+		f.metrics["self_time"].WithLabelValues(schema, name).Set(selfTime / 1000)
+	}
+
+	return rows.Err()
+}
+
+func (f *FunctionMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range f.metrics {
+		m.Describe(ch)
+	}
+	f.tracked.Describe(ch)
+}
+
+func (f *FunctionMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range f.metrics {
+		m.Collect(ch)
+	}
+	f.tracked.Collect(ch)
+}
+
+// check interface
+var _ Collection = new(FunctionMetrics)
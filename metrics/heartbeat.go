@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Heartbeat modes
+const (
+	// HeartbeatAuto writes heartbeat on primary and reads it on standby
+	HeartbeatAuto = "auto"
+	// HeartbeatRead only reads heartbeat, e.g. on logical replication subscriber
+	HeartbeatRead = "read"
+)
+
+// HeartbeatMetrics checks that the primary can commit by upserting a
+// timestamp into heartbeat table, and measures replication delay on
+// standbys and subscribers by reading it, the same way as pt-heartbeat.
+// The delay includes the whole replication chain, but depends on clocks of
+// servers being synchronized.
+type HeartbeatMetrics struct {
+	mutex   sync.Mutex
+	table   string
+	id      string
+	mode    string
+	created bool
+	commit  prometheus.Histogram
+	// delay is a vector without labels, so it is exported only after
+	// successful read and not on primary
+	delay       *prometheus.GaugeVec
+	writeErrors prometheus.Counter
+}
+
+// NewHeartbeatMetrics creates heartbeat collection, table must be a valid
+// possibly schema qualified identifier, id is key of the heartbeat row
+func NewHeartbeatMetrics(table, id, mode string) *HeartbeatMetrics {
+	return &HeartbeatMetrics{
+		table: table,
+		id:    id,
+		mode:  mode,
+		commit: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "heartbeat",
+			Name:      "commit_duration_seconds",
+			Help:      "Time to commit heartbeat on primary, including network round trip",
+			Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		}),
+		delay: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "heartbeat",
+			Name:      "delay_seconds",
+			Help:      "Time since heartbeat read on standby was written on primary",
+		}, nil),
+		writeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "heartbeat",
+			Name:      "write_errors_total",
+			Help:      "Number of failed heartbeat writes",
+		}),
+	}
+}
+
+func (h *HeartbeatMetrics) Scrape(db *sql.DB) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.delay.Reset()
+	inRecovery := true
+	if h.mode != HeartbeatRead {
+		err := db.QueryRow("SELECT pg_is_in_recovery()").Scan(&inRecovery)
+		if err != nil {
+			return errors.New("error checking recovery status: " + err.Error())
+		}
+	}
+
+	if inRecovery {
+		return h.read(db)
+	}
+
+	err := h.write(db)
+	if err != nil {
+		h.writeErrors.Inc()
+	}
+
+	return err
+}
+
+func (h *HeartbeatMetrics) write(db *sql.DB) error {
+	if !h.created {
+		_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + h.table + " (id text PRIMARY KEY, ts timestamptz NOT NULL)")
+		if err != nil {
+			return errors.New("error creating heartbeat table: " + err.Error())
+		}
+		h.created = true
+	}
+
+	start := time.Now()
+	_, err := db.Exec("INSERT INTO "+h.table+" (id, ts) VALUES ($1, now()) ON CONFLICT (id) DO UPDATE SET ts = excluded.ts", h.id)
+	if err != nil {
+		return errors.New("error writing heartbeat: " + err.Error())
+	}
+	h.commit.Observe(time.Since(start).Seconds())
+
+	return nil
+}
+
+func (h *HeartbeatMetrics) read(db *sql.DB) error {
+	var delay float64
+	err := db.QueryRow("SELECT extract(epoch FROM clock_timestamp() - ts) FROM "+h.table+" WHERE id = $1", h.id).Scan(&delay)
+	if err == sql.ErrNoRows {
+		return errors.New("heartbeat " + h.id + " not found in " + h.table)
+	}
+	if err != nil {
+		return errors.New("error reading heartbeat: " + err.Error())
+	}
+	h.delay.WithLabelValues().Set(delay)
+
+	return nil
+}
+
+func (h *HeartbeatMetrics) Describe(ch chan<- *prometheus.Desc) {
+	h.commit.Describe(ch)
+	h.delay.Describe(ch)
+	h.writeErrors.Describe(ch)
+}
+
+func (h *HeartbeatMetrics) Collect(ch chan<- prometheus.Metric) {
+	h.commit.Collect(ch)
+	h.delay.Collect(ch)
+	h.writeErrors.Collect(ch)
+}
+
+// check interface
+var _ Collection = new(HeartbeatMetrics)
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ioMetrics = map[string]metric{
+		"reads":           metric{Name: "reads_total", Help: "Number of read operations"},
+		"read_bytes":      metric{Name: "read_bytes_total", Help: "Amount of data read"},
+		"read_time":       metric{Name: "read_time_seconds_total", Help: "Time spent in read operations"},
+		"writes":          metric{Name: "writes_total", Help: "Number of write operations"},
+		"write_bytes":     metric{Name: "write_bytes_total", Help: "Amount of data written"},
+		"write_time":      metric{Name: "write_time_seconds_total", Help: "Time spent in write operations"},
+		"writebacks":      metric{Name: "writebacks_total", Help: "Number of units of size op_bytes which the process requested the kernel write out to permanent storage"},
+		"writeback_bytes": metric{Name: "writeback_bytes_total", Help: "Amount of data requested to be written out to permanent storage"},
+		"writeback_time":  metric{Name: "writeback_time_seconds_total", Help: "Time spent in writeback operations"},
+		"extends":         metric{Name: "extends_total", Help: "Number of relation extend operations"},
+		"extend_bytes":    metric{Name: "extend_bytes_total", Help: "Amount of data used to extend relations"},
+		"extend_time":     metric{Name: "extend_time_seconds_total", Help: "Time spent in extend operations"},
+		"hits":            metric{Name: "hits_total", Help: "Number of times a desired block was found in a shared buffer"},
+		"evictions":       metric{Name: "evictions_total", Help: "Number of times a block has been written out from a shared or local buffer in order to make it available for another use"},
+		"reuses":          metric{Name: "reuses_total", Help: "Number of times an existing buffer in a size-limited ring buffer outside of shared buffers was reused"},
+		"fsyncs":          metric{Name: "fsyncs_total", Help: "Number of fsync calls"},
+		"fsync_time":      metric{Name: "fsync_time_seconds_total", Help: "Time spent in fsync operations"},
+	}
+
+	// operations counted in units of op_bytes before PostgreSQL 18
+	ioOperations = map[string]string{
+		"reads":      "read_bytes",
+		"writes":     "write_bytes",
+		"writebacks": "writeback_bytes",
+		"extends":    "extend_bytes",
+	}
+
+	ioLabels = []string{"backend_type", "object", "context"}
+)
+
+// IOMetrics exports pg_stat_io, available since PostgreSQL 16.
+type IOMetrics struct {
+	mutex   sync.Mutex
+	metrics map[string]*prometheus.GaugeVec
+}
+
+func NewIOMetrics() *IOMetrics {
+	return &IOMetrics{
+		metrics: map[string]*prometheus.GaugeVec{},
+	}
+}
+
+func (i *IOMetrics) Scrape(db *sql.DB) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+	if version < 160000 {
+		return nil
+	}
+
+	rows, err := db.Query("SELECT * FROM pg_stat_io")
+	if err != nil {
+		return errors.New("error running io stats query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return errors.New("error running io stats query on database: " + err.Error())
+	}
+
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		args := make([]interface{}, len(cols))
+		for j := range vals {
+			args[j] = &vals[j]
+		}
+		err = rows.Scan(args...)
+		if err != nil {
+			return errors.New("error running io stats query on database: " + err.Error())
+		}
+
+		// numeric columns, e.g. *_bytes of PostgreSQL 18, are returned as
+		// text, so labels are told from values by column name
+		labelValues := make([]string, len(ioLabels))
+		values := make(map[string]float64)
+		for j, col := range cols {
+			if k := indexOf(ioLabels, col); k >= 0 {
+				labelValues[k] = asString(vals[j])
+				continue
+			}
+
+			switch v := vals[j].(type) {
+			case []byte:
+				val, err := strconv.ParseFloat(string(v), 64)
+				if err == nil {
+					values[col] = val
+				}
+			case int64:
+				values[col] = float64(v)
+			case float64:
+				values[col] = v
+			}
+		}
+
+		opBytes, hasOpBytes := values["op_bytes"]
+		delete(values, "op_bytes")
+		for col, val := range values {
+			if strings.HasSuffix(col, "_time") {
+				// timings are reported in milliseconds
+				val /= 1000
+			}
+			i.set(col, labelValues, val)
+
+			if bytesCol, ok := ioOperations[col]; ok && hasOpBytes {
+				i.set(bytesCol, labelValues, val*opBytes)
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func asString(v interface{}) string {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+
+	return ""
+}
+
+func (i *IOMetrics) set(col string, labelValues []string, val float64) {
+	if _, ok := i.metrics[col]; !ok {
+		m, ok := ioMetrics[col]
+		if !ok {
+			m = metric{Name: col, Help: "Value of pg_stat_io column " + col}
+		}
+		i.metrics[col] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "io",
+			Name:      m.Name,
+			Help:      m.Help,
+		}, ioLabels)
+	}
+
+	i.metrics[col].WithLabelValues(labelValues...).Set(val)
+}
+
+func (i *IOMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range i.metrics {
+		m.Describe(ch)
+	}
+}
+
+func (i *IOMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range i.metrics {
+		m.Collect(ch)
+	}
+}
+
+// check interface
+var _ Collection = new(IOMetrics)
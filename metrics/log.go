@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+const logPollInterval = time.Second
+
+// columns of csvlog records
+const (
+	csvUserName     = 1
+	csvDatabaseName = 2
+	csvSeverity     = 11
+	csvStateCode    = 12
+	csvMessage      = 13
+)
+
+var (
+	tempFileRe   = regexp.MustCompile(`^temporary file: path ".*", size (\d+)`)
+	autovacuumRe = regexp.MustCompile(`^automatic (?:aggressive )?(vacuum|analyze) (?:to prevent wraparound )?of table "([^"]*)"`)
+	elapsedRe    = regexp.MustCompile(`elapsed: ([0-9.]+) s`)
+)
+
+// logEntry is a server log record with fields used for metrics
+type logEntry struct {
+	User     string `json:"user"`
+	Database string `json:"dbname"`
+	Severity string `json:"error_severity"`
+	State    string `json:"state_code"`
+	Message  string `json:"message"`
+}
+
+// LogMetrics follows csvlog or jsonlog (PostgreSQL 15 and later) files of
+// the server and counts errors and events which are seen only in the log.
+// Log directory is read in background since the collection is created, so
+// it works even while the server is unreachable.
+type LogMetrics struct {
+	tailer             *logTailer
+	parse              func([]byte, func(logEntry)) int
+	messages           *prometheus.CounterVec
+	checkpointWarnings prometheus.Counter
+	tempFiles          *prometheus.CounterVec
+	tempBytes          *prometheus.CounterVec
+	autovacuum         *prometheus.HistogramVec
+}
+
+// NewLogMetrics creates log collection and starts following log files in
+// directory, format is csvlog or jsonlog
+func NewLogMetrics(dir, format string) *LogMetrics {
+	l := &LogMetrics{
+		tailer: &logTailer{dir: dir, ext: ".csv"},
+		parse:  parseCSVLog,
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "log",
+			Name:      "messages_total",
+			Help:      "Number of log messages by database, user, severity and SQLSTATE class",
+		}, []string{"db", "user", "severity", "class"}),
+		checkpointWarnings: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "log",
+			Name:      "checkpoint_warnings_total",
+			Help:      "Number of warnings that checkpoints are occurring too frequently",
+		}),
+		tempFiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "log",
+			Name:      "temp_files_total",
+			Help:      "Number of logged temporary files, see log_temp_files",
+		}, []string{"db", "user"}),
+		tempBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "log",
+			Name:      "temp_file_bytes_total",
+			Help:      "Total size of logged temporary files",
+		}, []string{"db", "user"}),
+		autovacuum: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "log",
+			Name:      "autovacuum_duration_seconds",
+			Help:      "Duration of logged autovacuum and autoanalyze runs, see log_autovacuum_min_duration",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600},
+		}, []string{"db", "operation"}),
+	}
+	if format == "jsonlog" {
+		l.tailer.ext = ".json"
+		l.parse = parseJSONLog
+	}
+
+	go l.follow()
+
+	return l
+}
+
+func (l *LogMetrics) follow() {
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+
+	failing := false
+	for range ticker.C {
+		err := l.tailer.poll(func(data []byte) int {
+			return l.parse(data, l.handle)
+		})
+		if err != nil && !failing {
+			// log only the first error of a series, polling is too frequent
+			log.Errorf("error reading server log: %s", err)
+		}
+		failing = err != nil
+	}
+}
+
+func (l *LogMetrics) handle(e logEntry) {
+	class := ""
+	if len(e.State) >= 2 {
+		class = e.State[:2]
+	}
+	l.messages.WithLabelValues(e.Database, e.User, e.Severity, class).Inc()
+
+	if strings.HasPrefix(e.Message, "checkpoints are occurring too frequently") {
+		l.checkpointWarnings.Inc()
+		return
+	}
+
+	if m := tempFileRe.FindStringSubmatch(e.Message); m != nil {
+		size, _ := strconv.ParseFloat(m[1], 64)
+		l.tempFiles.WithLabelValues(e.Database, e.User).Inc()
+		l.tempBytes.WithLabelValues(e.Database, e.User).Add(size)
+		return
+	}
+
+	if m := autovacuumRe.FindStringSubmatch(e.Message); m != nil {
+		elapsed := elapsedRe.FindStringSubmatch(e.Message)
+		if elapsed == nil {
+			return
+		}
+		seconds, _ := strconv.ParseFloat(elapsed[1], 64)
+		// autovacuum workers log without database, table name starts with it
+		db := strings.SplitN(m[2], ".", 2)[0]
+		l.autovacuum.WithLabelValues(db, m[1]).Observe(seconds)
+	}
+}
+
+// parseCSVLog handles complete records of data and returns their length,
+// messages may contain newlines, so a record may be not written completely yet
+func parseCSVLog(data []byte, handle func(logEntry)) int {
+	end := bytes.LastIndexByte(data, '\n') + 1
+	r := csv.NewReader(bytes.NewReader(data[:end]))
+	r.FieldsPerRecord = -1
+
+	consumed := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return end
+		}
+		if err != nil {
+			// the rest is an incomplete quoted field
+			return consumed
+		}
+		consumed = int(r.InputOffset())
+
+		if len(record) <= csvMessage {
+			continue
+		}
+		handle(logEntry{
+			User:     record[csvUserName],
+			Database: record[csvDatabaseName],
+			Severity: record[csvSeverity],
+			State:    record[csvStateCode],
+			Message:  record[csvMessage],
+		})
+	}
+}
+
+// parseJSONLog handles complete lines of data and returns their length
+func parseJSONLog(data []byte, handle func(logEntry)) int {
+	end := bytes.LastIndexByte(data, '\n') + 1
+	for _, line := range bytes.Split(data[:end], []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		var e logEntry
+		if json.Unmarshal(line, &e) == nil {
+			handle(e)
+		}
+	}
+
+	return end
+}
+
+// Independent marks the collection as not using database
+func (l *LogMetrics) Independent() {}
+
+func (l *LogMetrics) Scrape(db *sql.DB) error {
+	return nil
+}
+
+func (l *LogMetrics) Describe(ch chan<- *prometheus.Desc) {
+	l.messages.Describe(ch)
+	l.checkpointWarnings.Describe(ch)
+	l.tempFiles.Describe(ch)
+	l.tempBytes.Describe(ch)
+	l.autovacuum.Describe(ch)
+}
+
+func (l *LogMetrics) Collect(ch chan<- prometheus.Metric) {
+	l.messages.Collect(ch)
+	l.checkpointWarnings.Collect(ch)
+	l.tempFiles.Collect(ch)
+	l.tempBytes.Collect(ch)
+	l.autovacuum.Collect(ch)
+}
+
+// check interface
+var _ Independent = new(LogMetrics)
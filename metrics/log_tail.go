@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/log"
+)
+
+const (
+	// maxLogRead limits data read from log file at once
+	maxLogRead = 16 << 20
+	// maxPendingLog limits incomplete record kept until the rest is written
+	maxPendingLog = 1 << 20
+)
+
+// logTailer follows the newest file with given extension in a directory.
+// On rotation the current file is read to the end before switching to the
+// new one, truncated and recreated files are read from the beginning.
+type logTailer struct {
+	dir     string
+	ext     string
+	started bool
+	path    string
+	info    os.FileInfo
+	offset  int64
+	pending []byte
+}
+
+// newest returns the most recently modified log file of the directory
+func (t *logTailer) newest() (string, os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var path string
+	var info os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != t.ext {
+			continue
+		}
+		if info == nil || entry.ModTime().After(info.ModTime()) ||
+			(entry.ModTime().Equal(info.ModTime()) && entry.Name() > info.Name()) {
+			path, info = filepath.Join(t.dir, entry.Name()), entry
+		}
+	}
+
+	return path, info, nil
+}
+
+// poll reads data written since previous poll, consume gets pending data and
+// returns length of complete records, the rest is kept until next poll
+func (t *logTailer) poll(consume func([]byte) int) error {
+	path, info, err := t.newest()
+	if err != nil {
+		return err
+	}
+
+	if !t.started {
+		// history written before the exporter started is not replayed
+		t.started = true
+		if path != "" {
+			t.path, t.info, t.offset = path, info, info.Size()
+		}
+		return nil
+	}
+
+	// rotated file is read to the end, as it is not written anymore,
+	// otherwise at most maxLogRead bytes are read per poll
+	rotated := path != "" && path != t.path
+	for t.path != "" {
+		n, err := t.read(consume)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if !rotated || err != nil || n == 0 {
+			break
+		}
+	}
+
+	if rotated {
+		t.path, t.info, t.offset, t.pending = path, info, 0, nil
+		_, err = t.read(consume)
+		return err
+	}
+
+	return nil
+}
+
+// read reads at most maxLogRead bytes of the current file since previous
+// read and returns number of bytes read
+func (t *logTailer) read(consume func([]byte) int) (int, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !os.SameFile(info, t.info) || info.Size() < t.offset {
+		t.offset, t.pending = 0, nil
+	}
+	t.info = info
+
+	size := info.Size() - t.offset
+	if size == 0 {
+		return 0, nil
+	}
+	if size > maxLogRead {
+		size = maxLogRead
+	}
+
+	_, err = f.Seek(t.offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(f, size))
+	if err != nil {
+		return 0, err
+	}
+	t.offset += int64(len(data))
+
+	t.pending = append(t.pending, data...)
+	n := consume(t.pending)
+	t.pending = append([]byte(nil), t.pending[n:]...)
+	if len(t.pending) > maxPendingLog {
+		log.Warnf("skipping malformed record of log file %s", t.path)
+		t.pending = nil
+	}
+
+	return len(data), nil
+}
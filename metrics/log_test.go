@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// csvLogRecord returns csvlog record with fields used for metrics
+func csvLogRecord(user, db, severity, state, message string) string {
+	record := make([]string, 23)
+	record[0] = "2024-01-01 00:00:00.000 UTC"
+	record[csvUserName] = user
+	record[csvDatabaseName] = db
+	record[csvSeverity] = severity
+	record[csvStateCode] = state
+	record[csvMessage] = message
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(record)
+	w.Flush()
+
+	return buf.String()
+}
+
+func TestParseCSVLog(t *testing.T) {
+	auth := csvLogRecord("app", "orders", "FATAL", "28P01", `password authentication failed for user "app"`)
+	multiline := csvLogRecord("app", "orders", "ERROR", "42601", "syntax error at or near \"SELEC\"\nLINE 1: SELEC 1")
+	tests := []struct {
+		name     string
+		data     string
+		messages []string
+		consumed int
+	}{
+		{"complete records", auth + multiline, []string{`password authentication failed for user "app"`, "syntax error at or near \"SELEC\"\nLINE 1: SELEC 1"}, len(auth + multiline)},
+		{"partial trailing line", auth + multiline[:10], []string{`password authentication failed for user "app"`}, len(auth)},
+		{"partial multi-line message", auth + multiline[:strings.Index(multiline, "\n")+1], []string{`password authentication failed for user "app"`}, len(auth)},
+		{"short record", "a,b,c\n" + auth, []string{`password authentication failed for user "app"`}, len("a,b,c\n" + auth)},
+		{"no complete record", auth[:20], nil, 0},
+	}
+
+	for _, test := range tests {
+		var messages []string
+		consumed := parseCSVLog([]byte(test.data), func(e logEntry) {
+			messages = append(messages, e.Message)
+		})
+		if consumed != test.consumed {
+			t.Errorf("%s: expected %d bytes consumed, got %d", test.name, test.consumed, consumed)
+		}
+		if !reflect.DeepEqual(messages, test.messages) {
+			t.Errorf("%s: expected messages %q, got %q", test.name, test.messages, messages)
+		}
+	}
+
+	var entry logEntry
+	parseCSVLog([]byte(auth), func(e logEntry) { entry = e })
+	expected := logEntry{User: "app", Database: "orders", Severity: "FATAL", State: "28P01", Message: `password authentication failed for user "app"`}
+	if entry != expected {
+		t.Fatalf("expected %+v, got %+v", expected, entry)
+	}
+}
+
+func TestParseJSONLog(t *testing.T) {
+	auth := `{"user":"app","dbname":"orders","error_severity":"FATAL","state_code":"28P01","message":"password authentication failed"}` + "\n"
+	multiline := `{"user":"app","dbname":"orders","error_severity":"ERROR","state_code":"42601","message":"syntax error\nLINE 1: SELEC 1"}` + "\n"
+	tests := []struct {
+		name     string
+		data     string
+		messages []string
+		consumed int
+	}{
+		{"complete lines", auth + multiline, []string{"password authentication failed", "syntax error\nLINE 1: SELEC 1"}, len(auth + multiline)},
+		{"partial trailing line", auth + multiline[:30], []string{"password authentication failed"}, len(auth)},
+		{"malformed line", "{\"message\":\n" + auth, []string{"password authentication failed"}, len("{\"message\":\n" + auth)},
+		{"no complete line", auth[:30], nil, 0},
+	}
+
+	for _, test := range tests {
+		var messages []string
+		consumed := parseJSONLog([]byte(test.data), func(e logEntry) {
+			messages = append(messages, e.Message)
+		})
+		if consumed != test.consumed {
+			t.Errorf("%s: expected %d bytes consumed, got %d", test.name, test.consumed, consumed)
+		}
+		if !reflect.DeepEqual(messages, test.messages) {
+			t.Errorf("%s: expected messages %q, got %q", test.name, test.messages, messages)
+		}
+	}
+}
+
+// tailerTest writes log files and polls tailer, files get increasing
+// modification times, so the newest one doesn't depend on timer resolution
+type tailerTest struct {
+	t      *testing.T
+	tailer *logTailer
+	mtime  time.Time
+}
+
+func (tt *tailerTest) write(name, data string, flag int) {
+	path := filepath.Join(tt.tailer.dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0600)
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	_, err = f.WriteString(data)
+	f.Close()
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+
+	tt.mtime = tt.mtime.Add(time.Second)
+	if err := os.Chtimes(path, tt.mtime, tt.mtime); err != nil {
+		tt.t.Fatal(err)
+	}
+}
+
+func (tt *tailerTest) append(name, data string) { tt.write(name, data, os.O_APPEND) }
+
+// poll returns complete lines read by tailer
+func (tt *tailerTest) poll() []string {
+	var lines []string
+	err := tt.tailer.poll(func(data []byte) int {
+		end := bytes.LastIndexByte(data, '\n') + 1
+		if end > 0 {
+			lines = append(lines, strings.Split(string(data[:end-1]), "\n")...)
+		}
+		return end
+	})
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+
+	return lines
+}
+
+func (tt *tailerTest) expect(step string, expected ...string) {
+	if lines := tt.poll(); !reflect.DeepEqual(lines, expected) {
+		tt.t.Fatalf("%s: expected %q, got %q", step, expected, lines)
+	}
+}
+
+func TestLogTailer(t *testing.T) {
+	tt := &tailerTest{
+		t:      t,
+		tailer: &logTailer{dir: t.TempDir(), ext: ".log"},
+		mtime:  time.Now().Add(-time.Hour),
+	}
+
+	tt.append("a.log", "history\n")
+	tt.append("a.csv", "other format\n")
+	tt.expect("start")
+
+	tt.append("a.log", "one\n")
+	tt.expect("append", "one")
+
+	tt.append("a.log", "par")
+	tt.expect("partial record")
+	tt.append("a.log", "tial\n")
+	tt.expect("rest of record", "partial")
+
+	tt.write("a.log", "two\n", os.O_TRUNC)
+	tt.expect("truncation", "two")
+
+	tt.append("a.log", "three\n")
+	tt.append("b.log", "four\n")
+	tt.expect("rotation", "three", "four")
+
+	// new file is created before rename, so inode is not reused
+	tt.append("b.log.new", "five\n")
+	if err := os.Rename(filepath.Join(tt.tailer.dir, "b.log.new"), filepath.Join(tt.tailer.dir, "b.log")); err != nil {
+		t.Fatal(err)
+	}
+	tt.expect("recreation", "five")
+
+	tt.expect("no changes")
+}
+
+func TestLogTailerDrainsRotatedFile(t *testing.T) {
+	tt := &tailerTest{
+		t:      t,
+		tailer: &logTailer{dir: t.TempDir(), ext: ".log"},
+		mtime:  time.Now().Add(-time.Hour),
+	}
+
+	tt.append("a.log", "")
+	tt.expect("start")
+
+	// more than read at once is written before rotation
+	line := strings.Repeat("x", 1023)
+	count := maxLogRead/1024 + 100
+	tt.append("a.log", strings.Repeat(line+"\n", count)+"last\n")
+	tt.append("b.log", "first\n")
+
+	lines := tt.poll()
+	if len(lines) != count+2 {
+		t.Fatalf("expected %d lines, got %d", count+2, len(lines))
+	}
+	if lines[count] != "last" || lines[count+1] != "first" {
+		t.Fatalf("expected rest of rotated file before new one, got %q", lines[count:])
+	}
+
+	// current file is read by chunks
+	tt.append("b.log", strings.Repeat(line+"\n", count))
+	if lines := tt.poll(); len(lines) != maxLogRead/1024 {
+		t.Fatalf("expected %d lines, got %d", maxLogRead/1024, len(lines))
+	}
+	if lines := tt.poll(); len(lines) != 100 {
+		t.Fatalf("expected 100 lines, got %d", len(lines))
+	}
+}
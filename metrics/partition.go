@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// partitionsQuery gives partitions of the public schema with roots of their
+// partitioning trees, both declarative and inheritance ones. Roots are public
+// too, as tables are keyed by name without schema. Partitions are
+// ordered by oid descending, so the most recently created go first, names
+// and bounds don't sort reliably. %s tells whether partition is the default
+// one, which gets rows outside of bounds rather than the newest data.
+const partitionsQuery = `WITH RECURSIVE tree(relid, root) AS (
+		SELECT i.inhrelid, i.inhparent FROM pg_inherits i
+		WHERE NOT EXISTS (SELECT 1 FROM pg_inherits p WHERE p.inhrelid = i.inhparent)
+		UNION ALL
+		SELECT i.inhrelid, t.root FROM pg_inherits i JOIN tree t ON i.inhparent = t.relid
+	)
+	SELECT c.relname, r.relname, c.relkind = 'p', %s
+	FROM tree
+	JOIN pg_class c ON c.oid = tree.relid
+	JOIN pg_class r ON r.oid = tree.root
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	JOIN pg_namespace rn ON rn.oid = r.relnamespace
+	WHERE n.nspname = 'public' AND rn.nspname = 'public' AND c.relkind IN ('r', 'p', 'f') AND r.relkind IN ('r', 'p')
+	ORDER BY r.relname, c.oid DESC`
+
+// partitions maps partitions to roots of their partitioning trees, statistics
+// of partitions are summed up into their roots
+type partitions struct {
+	keepNewest int
+	roots      map[string]string
+	// newest partitions of each root, which are also exported separately
+	detailed map[string]struct{}
+}
+
+// partitionRow is a row of partitionsQuery
+type partitionRow struct {
+	name, root             string
+	partitioned, isDefault bool
+}
+
+func newPartitions(keepNewest int) *partitions {
+	return &partitions{keepNewest: keepNewest}
+}
+
+// load reads partitioning trees, they are read on each scrape as partitions
+// are usually created and dropped while the exporter runs
+func (p *partitions) load(db *sql.DB) error {
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+	// default partitions appeared in 11
+	isDefault := "false"
+	if version >= 110000 {
+		isDefault = "coalesce(pg_get_expr(c.relpartbound, c.oid) = 'DEFAULT', false)"
+	}
+
+	rows, err := db.Query(fmt.Sprintf(partitionsQuery, isDefault))
+	if err != nil {
+		return errors.New("error running partitions query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	var result []partitionRow
+	for rows.Next() {
+		var row partitionRow
+		err = rows.Scan(&row.name, &row.root, &row.partitioned, &row.isDefault)
+		if err != nil {
+			return errors.New("error running partitions query on database: " + err.Error())
+		}
+		result = append(result, row)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	p.set(result)
+
+	return nil
+}
+
+// set maps partitions to roots and picks newest partitions of each root,
+// rows are ordered as partitionsQuery orders them
+func (p *partitions) set(rows []partitionRow) {
+	roots := make(map[string]string)
+	detailed := make(map[string]struct{})
+	leaves := make(map[string]int)
+	for _, row := range rows {
+		if _, ok := roots[row.name]; ok {
+			// multiple inheritance, the first parent wins
+			continue
+		}
+		roots[row.name] = row.root
+
+		// partitioned tables in the middle of the tree have no data
+		if !row.partitioned && !row.isDefault && leaves[row.root] < p.keepNewest {
+			leaves[row.root]++
+			detailed[row.name] = struct{}{}
+		}
+	}
+
+	p.roots = roots
+	p.detailed = detailed
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPartitionsSet(t *testing.T) {
+	// rows as partitionsQuery orders them, newest partitions of root go first
+	rows := []partitionRow{
+		{name: "events_default", root: "events", isDefault: true},
+		{name: "events_2024_03", root: "events"},
+		{name: "events_2024", root: "events", partitioned: true},
+		{name: "events_2024_02", root: "events"},
+		{name: "events_2024_01", root: "events"},
+		{name: "events_2023_12", root: "events"},
+		{name: "orders_2024", root: "orders"},
+		{name: "orders_2023", root: "orders"},
+		// multiple inheritance, the first parent wins
+		{name: "events_2024_01", root: "logs"},
+		{name: "logs_2024", root: "logs"},
+	}
+
+	tests := []struct {
+		keepNewest int
+		detailed   []string
+	}{
+		{0, nil},
+		{1, []string{"events_2024_03", "orders_2024", "logs_2024"}},
+		{2, []string{"events_2024_03", "events_2024_02", "orders_2024", "orders_2023", "logs_2024"}},
+		{10, []string{"events_2024_03", "events_2024_02", "events_2024_01", "events_2023_12", "orders_2024", "orders_2023", "logs_2024"}},
+	}
+
+	for _, test := range tests {
+		p := newPartitions(test.keepNewest)
+		p.set(rows)
+
+		expected := make(map[string]struct{})
+		for _, name := range test.detailed {
+			expected[name] = struct{}{}
+		}
+		if !reflect.DeepEqual(p.detailed, expected) {
+			t.Errorf("newest %d: expected detailed %v, got %v", test.keepNewest, expected, p.detailed)
+		}
+
+		if len(p.roots) != 9 || p.roots["events_default"] != "events" || p.roots["events_2024_01"] != "events" || p.roots["logs_2024"] != "logs" {
+			t.Errorf("newest %d: unexpected roots %v", test.keepNewest, p.roots)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type pgBouncerColumn struct {
+	// key of metric, columns with the same key are summed up
+	key string
+	// multiplier to convert column value to base units
+	scale float64
+}
+
+// pgBouncerCommand describes SHOW command of PgBouncer admin console.
+type pgBouncerCommand struct {
+	command string
+	// label names by column names
+	labels  map[string]string
+	columns map[string]pgBouncerColumn
+	metrics map[string]metric
+}
+
+var pgBouncerCommands = []pgBouncerCommand{
+	{
+		command: "SHOW POOLS",
+		labels:  map[string]string{"database": "database", "user": "user"},
+		columns: map[string]pgBouncerColumn{
+			"cl_active":             {key: "cl_active", scale: 1},
+			"cl_waiting":            {key: "cl_waiting", scale: 1},
+			"cl_active_cancel_req":  {key: "cl_active_cancel_req", scale: 1},
+			"cl_waiting_cancel_req": {key: "cl_waiting_cancel_req", scale: 1},
+			"sv_active":             {key: "sv_active", scale: 1},
+			"sv_active_cancel":      {key: "sv_active_cancel", scale: 1},
+			"sv_being_canceled":     {key: "sv_being_canceled", scale: 1},
+			"sv_idle":               {key: "sv_idle", scale: 1},
+			"sv_used":               {key: "sv_used", scale: 1},
+			"sv_tested":             {key: "sv_tested", scale: 1},
+			"sv_login":              {key: "sv_login", scale: 1},
+			"maxwait":               {key: "maxwait", scale: 1},
+			"maxwait_us":            {key: "maxwait", scale: 1e-6},
+		},
+		metrics: map[string]metric{
+			"cl_active":             metric{Name: "pools_client_active_connections", Help: "Client connections that are linked to server connection and can process queries"},
+			"cl_waiting":            metric{Name: "pools_client_waiting_connections", Help: "Client connections that have sent queries but have not yet got a server connection"},
+			"cl_active_cancel_req":  metric{Name: "pools_client_active_cancel_connections", Help: "Client connections that have forwarded query cancellations to the server and are waiting for the server response"},
+			"cl_waiting_cancel_req": metric{Name: "pools_client_waiting_cancel_connections", Help: "Client connections that have not forwarded query cancellations to the server yet"},
+			"sv_active":             metric{Name: "pools_server_active_connections", Help: "Server connections that are linked to a client"},
+			"sv_active_cancel":      metric{Name: "pools_server_active_cancel_connections", Help: "Server connections that are currently forwarding a cancel request"},
+			"sv_being_canceled":     metric{Name: "pools_server_being_canceled_connections", Help: "Servers that normally could become idle but are waiting to do so until all in-flight cancel requests have completed"},
+			"sv_idle":               metric{Name: "pools_server_idle_connections", Help: "Server connections that are unused and immediately usable for client queries"},
+			"sv_used":               metric{Name: "pools_server_used_connections", Help: "Server connections that have been idle for more than server_check_delay"},
+			"sv_tested":             metric{Name: "pools_server_tested_connections", Help: "Server connections that are currently running either server_reset_query or server_check_query"},
+			"sv_login":              metric{Name: "pools_server_login_connections", Help: "Server connections currently in the process of logging in"},
+			"maxwait":               metric{Name: "pools_client_maxwait_seconds", Help: "How long the first (oldest) client in the queue has waited"},
+		},
+	},
+	{
+		command: "SHOW STATS",
+		labels:  map[string]string{"database": "database"},
+		columns: map[string]pgBouncerColumn{
+			"total_xact_count":              {key: "total_xact_count", scale: 1},
+			"total_query_count":             {key: "total_query_count", scale: 1},
+			"total_server_assignment_count": {key: "total_server_assignment_count", scale: 1},
+			"total_received":                {key: "total_received", scale: 1},
+			"total_sent":                    {key: "total_sent", scale: 1},
+			"total_xact_time":               {key: "total_xact_time", scale: 1e-6},
+			"total_query_time":              {key: "total_query_time", scale: 1e-6},
+			"total_wait_time":               {key: "total_wait_time", scale: 1e-6},
+		},
+		metrics: map[string]metric{
+			"total_xact_count":              metric{Name: "stats_transactions_total", Help: "Total number of SQL transactions pooled by PgBouncer"},
+			"total_query_count":             metric{Name: "stats_queries_total", Help: "Total number of SQL queries pooled by PgBouncer"},
+			"total_server_assignment_count": metric{Name: "stats_server_assignments_total", Help: "Total times a server was assigned to a client"},
+			"total_received":                metric{Name: "stats_received_bytes_total", Help: "Total volume in bytes of network traffic received by PgBouncer"},
+			"total_sent":                    metric{Name: "stats_sent_bytes_total", Help: "Total volume in bytes of network traffic sent by PgBouncer"},
+			"total_xact_time":               metric{Name: "stats_transaction_time_seconds_total", Help: "Total time spent by PgBouncer when connected to PostgreSQL in a transaction"},
+			"total_query_time":              metric{Name: "stats_query_time_seconds_total", Help: "Total time spent by PgBouncer when actively connected to PostgreSQL"},
+			"total_wait_time":               metric{Name: "stats_client_wait_time_seconds_total", Help: "Time spent by clients waiting for a server"},
+		},
+	},
+	{
+		command: "SHOW DATABASES",
+		labels:  map[string]string{"name": "database"},
+		columns: map[string]pgBouncerColumn{
+			"pool_size":           {key: "pool_size", scale: 1},
+			"min_pool_size":       {key: "min_pool_size", scale: 1},
+			"reserve_pool":        {key: "reserve_pool", scale: 1},
+			"max_connections":     {key: "max_connections", scale: 1},
+			"current_connections": {key: "current_connections", scale: 1},
+			"paused":              {key: "paused", scale: 1},
+			"disabled":            {key: "disabled", scale: 1},
+		},
+		metrics: map[string]metric{
+			"pool_size":           metric{Name: "databases_pool_size", Help: "Maximum number of server connections"},
+			"min_pool_size":       metric{Name: "databases_min_pool_size", Help: "Minimum number of server connections"},
+			"reserve_pool":        metric{Name: "databases_reserve_pool_size", Help: "Maximum number of additional connections for this database"},
+			"max_connections":     metric{Name: "databases_max_connections", Help: "Maximum number of allowed connections for this database"},
+			"current_connections": metric{Name: "databases_current_connections", Help: "Current number of connections for this database"},
+			"paused":              metric{Name: "databases_paused", Help: "1 if this database is currently paused, else 0"},
+			"disabled":            metric{Name: "databases_disabled", Help: "1 if this database is currently disabled, else 0"},
+		},
+	},
+	{
+		command: "SHOW LISTS",
+		labels:  map[string]string{"list": "list"},
+		columns: map[string]pgBouncerColumn{
+			"items": {key: "items", scale: 1},
+		},
+		metrics: map[string]metric{
+			"items": metric{Name: "lists_items", Help: "Count of items in PgBouncer internal list"},
+		},
+	},
+}
+
+// PgBouncerMetrics exports output of SHOW command of PgBouncer admin
+// console. Admin console supports only simple query protocol, so
+// queries must not have parameters.
+type PgBouncerMetrics struct {
+	mutex   sync.Mutex
+	cmd     pgBouncerCommand
+	names   []string
+	metrics map[string]*prometheus.GaugeVec
+}
+
+// NewPgBouncerCollections returns collections for PgBouncer targets.
+func NewPgBouncerCollections() []Collection {
+	var collections []Collection
+	for _, cmd := range pgBouncerCommands {
+		collections = append(collections, newPgBouncerMetrics(cmd))
+	}
+
+	return collections
+}
+
+func newPgBouncerMetrics(cmd pgBouncerCommand) *PgBouncerMetrics {
+	var columns, names []string
+	for column, name := range cmd.labels {
+		columns = append(columns, column)
+		names = append(names, name)
+	}
+
+	metrics := make(map[string]*prometheus.GaugeVec)
+	for key, m := range cmd.metrics {
+		metrics[key] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "pgbouncer",
+			Name:      m.Name,
+			Help:      m.Help,
+		}, names)
+	}
+
+	return &PgBouncerMetrics{
+		cmd:     cmd,
+		names:   columns,
+		metrics: metrics,
+	}
+}
+
+func (p *PgBouncerMetrics) Scrape(db *sql.DB) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	rows, err := db.Query(p.cmd.command)
+	if err != nil {
+		return errors.New("error running " + p.cmd.command + " on pgbouncer: " + err.Error())
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return errors.New("error running " + p.cmd.command + " on pgbouncer: " + err.Error())
+	}
+
+	// pools and databases come and go with configuration reloads
+	for _, m := range p.metrics {
+		m.Reset()
+	}
+
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		args := make([]interface{}, len(cols))
+		for i := range vals {
+			args[i] = &vals[i]
+		}
+		err = rows.Scan(args...)
+		if err != nil {
+			return errors.New("error running " + p.cmd.command + " on pgbouncer: " + err.Error())
+		}
+
+		labels := make(map[string]string)
+		values := make(map[string]float64)
+		for i, col := range cols {
+			if _, ok := p.cmd.labels[col]; ok {
+				labels[col] = toString(vals[i])
+			}
+			if column, ok := p.cmd.columns[col]; ok {
+				values[column.key] += toFloat(vals[i]) * column.scale
+			}
+		}
+
+		labelValues := make([]string, len(p.names))
+		for i, col := range p.names {
+			labelValues[i] = labels[col]
+		}
+		for key, val := range values {
+			p.metrics[key].WithLabelValues(labelValues...).Set(val)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (p *PgBouncerMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range p.metrics {
+		m.Describe(ch)
+	}
+}
+
+func (p *PgBouncerMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range p.metrics {
+		m.Collect(ch)
+	}
+}
+
+func toString(val interface{}) string {
+	switch v := val.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+
+	return ""
+}
+
+func toFloat(val interface{}) float64 {
+	switch v := val.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	case []byte, string:
+		f, _ := strconv.ParseFloat(toString(v), 64)
+		return f
+	}
+
+	return 0
+}
+
+// check interface
+var _ Collection = new(PgBouncerMetrics)
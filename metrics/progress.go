@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type progressView struct {
+	operation  string
+	unit       string
+	minVersion int
+	// query must return pid, datname, relation, phase, completed and total units
+	query string
+}
+
+// progressRelation resolves relation name only in the current database, views
+// list operations of all databases, where the same oid means other relation
+const progressRelation = "CASE WHEN datname = current_database() THEN relid::regclass::text ELSE relid::text END"
+
+var progressViews = []progressView{
+	{
+		operation:  "vacuum",
+		unit:       "blocks",
+		minVersion: 90600,
+		query: `SELECT pid, datname, coalesce(` + progressRelation + `, ''), phase, heap_blks_scanned, heap_blks_total
+			FROM pg_stat_progress_vacuum`,
+	},
+	{
+		operation:  "analyze",
+		unit:       "blocks",
+		minVersion: 130000,
+		query: `SELECT pid, datname, coalesce(` + progressRelation + `, ''), phase, sample_blks_scanned, sample_blks_total
+			FROM pg_stat_progress_analyze`,
+	},
+	{
+		operation:  "create_index",
+		unit:       "blocks",
+		minVersion: 120000,
+		query: `SELECT pid, datname, coalesce(` + progressRelation + `, ''), phase, blocks_done, blocks_total
+			FROM pg_stat_progress_create_index`,
+	},
+	{
+		operation:  "create_index",
+		unit:       "tuples",
+		minVersion: 120000,
+		query: `SELECT pid, datname, coalesce(` + progressRelation + `, ''), phase, tuples_done, tuples_total
+			FROM pg_stat_progress_create_index`,
+	},
+	{
+		operation:  "cluster",
+		unit:       "blocks",
+		minVersion: 120000,
+		query: `SELECT pid, datname, coalesce(` + progressRelation + `, ''), phase, heap_blks_scanned, heap_blks_total
+			FROM pg_stat_progress_cluster`,
+	},
+	{
+		operation:  "basebackup",
+		unit:       "bytes",
+		minVersion: 130000,
+		query: `SELECT pid, '', '', phase, backup_streamed, coalesce(backup_total, 0)
+			FROM pg_stat_progress_basebackup`,
+	},
+	{
+		operation:  "copy",
+		unit:       "bytes",
+		minVersion: 140000,
+		query: `SELECT pid, datname, coalesce(` + progressRelation + `, ''), command, bytes_processed, bytes_total
+			FROM pg_stat_progress_copy`,
+	},
+}
+
+// ProgressMetrics reports progress of running maintenance operations
+// from pg_stat_progress_* views supported by the server.
+type ProgressMetrics struct {
+	mutex   sync.Mutex
+	metrics map[string]*prometheus.GaugeVec
+}
+
+func NewProgressMetrics() *ProgressMetrics {
+	labels := []string{"operation", "pid", "db", "relation", "phase", "unit"}
+
+	return &ProgressMetrics{
+		metrics: map[string]*prometheus.GaugeVec{
+			"completed": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "progress",
+				Name:      "completed",
+				Help:      "Units of work completed by running operation",
+			}, labels),
+			"total": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "progress",
+				Name:      "total",
+				Help:      "Total units of work of running operation, 0 if unknown",
+			}, labels),
+		},
+	}
+}
+
+func (p *ProgressMetrics) Scrape(db *sql.DB) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+
+	// operations come and go, so export only currently running ones
+	for _, m := range p.metrics {
+		m.Reset()
+	}
+
+	for _, view := range progressViews {
+		if version < view.minVersion {
+			continue
+		}
+
+		err = p.scrapeView(db, view)
+		if err != nil {
+			return errors.New("error running " + view.operation + " progress query on database: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (p *ProgressMetrics) scrapeView(db *sql.DB, view progressView) error {
+	rows, err := db.Query(view.query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pid, datname, relation, phase string
+		var completed, total float64
+		err = rows.Scan(&pid, &datname, &relation, &phase, &completed, &total)
+		if err != nil {
+			return err
+		}
+
+		p.metrics["completed"].WithLabelValues(view.operation, pid, datname, relation, phase, view.unit).Set(completed)
+		p.metrics["total"].WithLabelValues(view.operation, pid, datname, relation, phase, view.unit).Set(total)
+	}
+
+	return rows.Err()
+}
+
+func (p *ProgressMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range p.metrics {
+		m.Describe(ch)
+	}
+}
+
+func (p *ProgressMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range p.metrics {
+		m.Collect(ch)
+	}
+}
+
+// check interface
+var _ Collection = new(ProgressMetrics)
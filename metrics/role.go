@@ -0,0 +1,251 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	roleValidUntilQuery = `SELECT rolname, extract(epoch FROM rolvaliduntil - now()) FROM pg_roles
+		WHERE rolcanlogin AND rolvaliduntil IS NOT NULL AND rolvaliduntil <> 'infinity'`
+
+	roleConnectionsQuery = `SELECT r.rolname, r.rolconnlimit, count(a.pid)
+		FROM pg_roles r LEFT JOIN pg_stat_activity a ON a.usesysid = r.oid
+		WHERE r.rolcanlogin GROUP BY r.rolname, r.rolconnlimit`
+
+	dbConnectionsQuery = `SELECT d.datname, d.datconnlimit, d.datallowconn::int, count(a.pid)
+		FROM pg_database d LEFT JOIN pg_stat_activity a ON a.datid = d.oid
+		GROUP BY d.datname, d.datconnlimit, d.datallowconn`
+)
+
+// roleAttributes are pg_roles columns roles are counted by, bypassrls appeared in 9.5
+var roleAttributes = []struct {
+	name, column string
+	minVersion   int
+}{
+	{"superuser", "rolsuper", 0},
+	{"replication", "rolreplication", 0},
+	{"bypassrls", "rolbypassrls", 90500},
+	{"login", "rolcanlogin", 0},
+}
+
+// RoleMetrics reports privileged roles, password expiration and connection
+// limits of roles and databases
+type RoleMetrics struct {
+	mutex   sync.Mutex
+	metrics map[string]*prometheus.GaugeVec
+}
+
+func NewRoleMetrics() *RoleMetrics {
+	return &RoleMetrics{
+		metrics: map[string]*prometheus.GaugeVec{
+			"count": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "roles",
+				Name:      "count",
+				Help:      "Number of roles having attribute: superuser, replication, bypassrls or login",
+			}, []string{"attribute"}),
+			"valid_until": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "roles",
+				Name:      "valid_until_seconds",
+				Help:      "Time until password of login role expires, negative when already expired",
+			}, []string{"role"}),
+			"role_connections": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "roles",
+				Name:      "connections",
+				Help:      "Number of connections of login role",
+			}, []string{"role"}),
+			"role_connection_limit": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "roles",
+				Name:      "connection_limit",
+				Help:      "Maximum number of connections of login role, only roles with a limit are exported",
+			}, []string{"role"}),
+			"db_connections": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "databases",
+				Name:      "connections",
+				Help:      "Number of connections to database",
+			}, []string{"db"}),
+			"db_connection_limit": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "databases",
+				Name:      "connection_limit",
+				Help:      "Maximum number of connections to database, only databases with a limit are exported",
+			}, []string{"db"}),
+			"db_allow_connections": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "databases",
+				Name:      "allow_connections",
+				Help:      "Whether connections to database are allowed",
+			}, []string{"db"}),
+		},
+	}
+}
+
+func (r *RoleMetrics) Scrape(db *sql.DB) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if wanted("roles", "count") {
+		err := r.getRoleCounts(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if wanted("roles", "valid_until_seconds") {
+		err := r.getValidUntil(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if wanted("roles", "connections") || wanted("roles", "connection_limit") {
+		err := r.getRoleConnections(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if wanted("databases", "connections") || wanted("databases", "connection_limit") || wanted("databases", "allow_connections") {
+		err := r.getDBConnections(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *RoleMetrics) getRoleCounts(db *sql.DB) error {
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT "
+	var names []string
+	for _, attr := range roleAttributes {
+		if version < attr.minVersion {
+			continue
+		}
+		if len(names) > 0 {
+			query += ", "
+		}
+		query += "coalesce(sum(" + attr.column + "::int), 0)"
+		names = append(names, attr.name)
+	}
+
+	counts := make([]float64, len(names))
+	args := make([]interface{}, len(names))
+	for i := range counts {
+		args[i] = &counts[i]
+	}
+	err = db.QueryRow(query + " FROM pg_roles").Scan(args...)
+	if err != nil {
+		return errors.New("error running role count query on database: " + err.Error())
+	}
+
+	for i, name := range names {
+		r.metrics["count"].WithLabelValues(name).Set(counts[i])
+	}
+
+	return nil
+}
+
+func (r *RoleMetrics) getValidUntil(db *sql.DB) error {
+	rows, err := db.Query(roleValidUntilQuery)
+	if err != nil {
+		return errors.New("error running role expiration query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	r.metrics["valid_until"].Reset()
+	for rows.Next() {
+		var name string
+		var seconds float64
+		err = rows.Scan(&name, &seconds)
+		if err != nil {
+			return errors.New("error running role expiration query on database: " + err.Error())
+		}
+		r.metrics["valid_until"].WithLabelValues(name).Set(seconds)
+	}
+
+	return rows.Err()
+}
+
+func (r *RoleMetrics) getRoleConnections(db *sql.DB) error {
+	rows, err := db.Query(roleConnectionsQuery)
+	if err != nil {
+		return errors.New("error running role connections query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	r.metrics["role_connections"].Reset()
+	r.metrics["role_connection_limit"].Reset()
+	for rows.Next() {
+		var name string
+		var limit, count float64
+		err = rows.Scan(&name, &limit, &count)
+		if err != nil {
+			return errors.New("error running role connections query on database: " + err.Error())
+		}
+
+		r.metrics["role_connections"].WithLabelValues(name).Set(count)
+		if limit >= 0 {
+			// -1 means no limit
+			r.metrics["role_connection_limit"].WithLabelValues(name).Set(limit)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *RoleMetrics) getDBConnections(db *sql.DB) error {
+	rows, err := db.Query(dbConnectionsQuery)
+	if err != nil {
+		return errors.New("error running database connections query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	r.metrics["db_connections"].Reset()
+	r.metrics["db_connection_limit"].Reset()
+	r.metrics["db_allow_connections"].Reset()
+	for rows.Next() {
+		var name string
+		var limit, allow, count float64
+		err = rows.Scan(&name, &limit, &allow, &count)
+		if err != nil {
+			return errors.New("error running database connections query on database: " + err.Error())
+		}
+
+		r.metrics["db_connections"].WithLabelValues(name).Set(count)
+		if limit >= 0 {
+			r.metrics["db_connection_limit"].WithLabelValues(name).Set(limit)
+		}
+		r.metrics["db_allow_connections"].WithLabelValues(name).Set(allow)
+	}
+
+	return rows.Err()
+}
+
+func (r *RoleMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range r.metrics {
+		m.Describe(ch)
+	}
+}
+
+func (r *RoleMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range r.metrics {
+		m.Collect(ch)
+	}
+}
+
+// check interface
+var _ Collection = new(RoleMetrics)
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// schemaObjectsQuery gives kind, name and definition of user objects,
+// system schemas are skipped
+const schemaObjectsQuery = `WITH ns AS (
+		SELECT oid, nspname FROM pg_namespace
+		WHERE nspname NOT IN ('pg_catalog', 'information_schema')
+			AND nspname NOT LIKE 'pg_toast%' AND nspname NOT LIKE 'pg_temp%'
+	)
+	SELECT 'table', ns.nspname || '.' || c.relname, c.relkind::text
+		FROM pg_class c JOIN ns ON ns.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f')
+	UNION ALL
+	SELECT 'column', ns.nspname || '.' || c.relname || '.' || a.attname,
+			format_type(a.atttypid, a.atttypmod) || CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN ns ON ns.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f') AND a.attnum > 0 AND NOT a.attisdropped
+	UNION ALL
+	SELECT 'index', ns.nspname || '.' || c.relname, pg_get_indexdef(c.oid)
+		FROM pg_class c JOIN ns ON ns.oid = c.relnamespace
+		WHERE c.relkind IN ('i', 'I')
+	UNION ALL
+	SELECT 'constraint', ns.nspname || '.' || c.relname || '.' || con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN ns ON ns.oid = c.relnamespace
+	UNION ALL
+	SELECT 'function', ns.nspname || '.' || p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')',
+			coalesce(pg_get_function_result(p.oid), '') || ' ' || md5(coalesce(p.prosrc, ''))
+		FROM pg_proc p JOIN ns ON ns.oid = p.pronamespace`
+
+// fingerprintMask keeps hash within 53 bits, which float64 holds exactly
+const fingerprintMask = 1<<53 - 1
+
+// SchemaMetrics fingerprints schema of monitored databases, so databases
+// which should have the same schema can be compared. Catalogs of large
+// schemas are heavy to read, so they are read at most once per interval.
+type SchemaMetrics struct {
+	mutex      sync.Mutex
+	names      []string
+	connect    DBConnector
+	interval   time.Duration
+	lastScrape time.Time
+	// definition hashes of objects by database seen on previous scrape
+	objects map[string]map[string]uint64
+	metrics map[string]*prometheus.GaugeVec
+	changes *prometheus.CounterVec
+}
+
+func NewSchemaMetrics(dbNames []string, connect DBConnector, interval time.Duration) *SchemaMetrics {
+	return &SchemaMetrics{
+		names:    dbNames,
+		connect:  connect,
+		interval: interval,
+		objects:  make(map[string]map[string]uint64),
+		metrics: map[string]*prometheus.GaugeVec{
+			"fingerprint": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "schema",
+				Name:      "fingerprint",
+				Help:      "Hash of tables, columns, indexes, constraints and functions of database",
+			}, []string{"db"}),
+			"objects": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "schema",
+				Name:      "objects",
+				Help:      "Number of schema objects by kind: table, column, index, constraint or function",
+			}, []string{"db", "kind"}),
+		},
+		changes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "schema",
+			Name:      "changes_total",
+			Help:      "Number of schema objects created, dropped or altered between scrapes",
+		}, []string{"db"}),
+	}
+}
+
+func (s *SchemaMetrics) Scrape(db *sql.DB) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if time.Since(s.lastScrape) < s.interval {
+		return nil
+	}
+	// failed attempts count too, so heavy queries don't run on every scrape
+	s.lastScrape = time.Now()
+
+	for _, name := range s.names {
+		err := s.getSchema(s.connect(name), name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SchemaMetrics) getSchema(db *sql.DB, dbName string) error {
+	rows, err := db.Query(schemaObjectsQuery)
+	if err != nil {
+		return errors.New("error running schema query on database " + dbName + ": " + err.Error())
+	}
+	defer rows.Close()
+
+	objects := make(map[string]uint64)
+	counts := map[string]float64{"table": 0, "column": 0, "index": 0, "constraint": 0, "function": 0}
+	for rows.Next() {
+		var kind, name, definition string
+		err = rows.Scan(&kind, &name, &definition)
+		if err != nil {
+			return errors.New("error running schema query on database " + dbName + ": " + err.Error())
+		}
+
+		h := fnv.New64a()
+		h.Write([]byte(definition))
+		objects[kind+" "+name] = h.Sum64()
+		counts[kind]++
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// objects are hashed in stable order, which doesn't depend on collation
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	for _, key := range keys {
+		h.Write([]byte(key))
+		binary.LittleEndian.PutUint64(buf, objects[key])
+		h.Write(buf)
+	}
+	s.metrics["fingerprint"].WithLabelValues(dbName).Set(float64(h.Sum64() & fingerprintMask))
+
+	for kind, count := range counts {
+		s.metrics["objects"].WithLabelValues(dbName, kind).Set(count)
+	}
+
+	changes := s.changes.WithLabelValues(dbName)
+	if prev, ok := s.objects[dbName]; ok {
+		for key, hash := range objects {
+			if prevHash, ok := prev[key]; !ok || prevHash != hash {
+				changes.Inc()
+			}
+		}
+		for key := range prev {
+			if _, ok := objects[key]; !ok {
+				changes.Inc()
+			}
+		}
+	}
+	s.objects[dbName] = objects
+
+	return nil
+}
+
+func (s *SchemaMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range s.metrics {
+		m.Describe(ch)
+	}
+	s.changes.Describe(ch)
+}
+
+func (s *SchemaMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range s.metrics {
+		m.Collect(ch)
+	}
+	s.changes.Collect(ch)
+}
+
+// check interface
+var _ Collection = new(SchemaMetrics)
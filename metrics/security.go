@@ -0,0 +1,206 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+const (
+	// client_port is -1 for unix socket connections and null for background processes
+	sslConnectionsQuery = `SELECT CASE WHEN a.client_port = -1 THEN 'unix' ELSE 'tcp' END,
+		s.ssl, coalesce(s.version, ''), coalesce(s.cipher, ''), count(*)
+		FROM pg_stat_ssl s JOIN pg_stat_activity a ON a.pid = s.pid
+		WHERE a.client_port IS NOT NULL
+		GROUP BY 1, 2, 3, 4`
+
+	gssapiConnectionsQuery = `SELECT g.gss_authenticated, g.encrypted, count(*)
+		FROM pg_stat_gssapi g JOIN pg_stat_activity a ON a.pid = g.pid
+		WHERE a.client_port IS NOT NULL
+		GROUP BY 1, 2`
+
+	hbaRulesQuery = `SELECT coalesce(auth_method, ''), count(*) FILTER (WHERE error IS NULL), count(error)
+		FROM pg_hba_file_rules GROUP BY 1`
+)
+
+// SecurityMetrics reports encryption of client connections and insecure
+// authentication methods in pg_hba.conf
+type SecurityMetrics struct {
+	mutex   sync.Mutex
+	warned  bool
+	metrics map[string]*prometheus.GaugeVec
+	errors  prometheus.Gauge
+}
+
+func NewSecurityMetrics() *SecurityMetrics {
+	return &SecurityMetrics{
+		metrics: map[string]*prometheus.GaugeVec{
+			"ssl": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "ssl",
+				Name:      "connections",
+				Help:      "Number of client connections by transport, SSL status, protocol version and cipher",
+			}, []string{"transport", "ssl", "version", "cipher"}),
+			"gssapi": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "gssapi",
+				Name:      "connections",
+				Help:      "Number of client connections by GSSAPI authentication and encryption status",
+			}, []string{"authenticated", "encrypted"}),
+			"hba_rules": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "hba",
+				Name:      "rules",
+				Help:      "Number of pg_hba.conf rules by authentication method",
+			}, []string{"auth_method"}),
+		},
+		errors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "hba",
+			Name:      "rule_errors",
+			Help:      "Number of pg_hba.conf rules which can't be parsed",
+		}),
+	}
+}
+
+func (s *SecurityMetrics) Scrape(db *sql.DB) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+
+	// pg_stat_ssl appeared in 9.5
+	if version >= 90500 && wanted("ssl", "connections") {
+		err = s.getSSLConnections(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if version >= 120000 && wanted("gssapi", "connections") {
+		err = s.getGSSAPIConnections(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if version >= 100000 && (wanted("hba", "rules") || wanted("hba", "rule_errors")) {
+		err = s.getHBARules(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SecurityMetrics) getSSLConnections(db *sql.DB) error {
+	rows, err := db.Query(sslConnectionsQuery)
+	if err != nil {
+		return errors.New("error running ssl connections query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	s.metrics["ssl"].Reset()
+	for rows.Next() {
+		var transport, version, cipher string
+		var ssl bool
+		var count float64
+		err = rows.Scan(&transport, &ssl, &version, &cipher, &count)
+		if err != nil {
+			return errors.New("error running ssl connections query on database: " + err.Error())
+		}
+		s.metrics["ssl"].WithLabelValues(transport, strconv.FormatBool(ssl), version, cipher).Set(count)
+	}
+
+	return rows.Err()
+}
+
+func (s *SecurityMetrics) getGSSAPIConnections(db *sql.DB) error {
+	rows, err := db.Query(gssapiConnectionsQuery)
+	if err != nil {
+		return errors.New("error running gssapi connections query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	s.metrics["gssapi"].Reset()
+	for rows.Next() {
+		var authenticated, encrypted bool
+		var count float64
+		err = rows.Scan(&authenticated, &encrypted, &count)
+		if err != nil {
+			return errors.New("error running gssapi connections query on database: " + err.Error())
+		}
+		s.metrics["gssapi"].WithLabelValues(strconv.FormatBool(authenticated), strconv.FormatBool(encrypted)).Set(count)
+	}
+
+	return rows.Err()
+}
+
+func (s *SecurityMetrics) getHBARules(db *sql.DB) error {
+	// pg_hba_file_rules is readable only by superusers by default
+	var readable bool
+	err := db.QueryRow("SELECT has_table_privilege('pg_catalog.pg_hba_file_rules', 'SELECT')").Scan(&readable)
+	if err != nil {
+		return errors.New("error checking pg_hba_file_rules privilege: " + err.Error())
+	}
+	if !readable {
+		if !s.warned {
+			log.Warn("pg_hba_file_rules is not readable, pg_hba.conf rules are not collected")
+			s.warned = true
+		}
+		return nil
+	}
+	s.warned = false
+
+	rows, err := db.Query(hbaRulesQuery)
+	if err != nil {
+		return errors.New("error running pg_hba.conf rules query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	s.metrics["hba_rules"].Reset()
+	var total float64
+	for rows.Next() {
+		var method string
+		var count, ruleErrors float64
+		err = rows.Scan(&method, &count, &ruleErrors)
+		if err != nil {
+			return errors.New("error running pg_hba.conf rules query on database: " + err.Error())
+		}
+		if count > 0 {
+			s.metrics["hba_rules"].WithLabelValues(method).Set(count)
+		}
+		total += ruleErrors
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	s.errors.Set(total)
+
+	return nil
+}
+
+func (s *SecurityMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range s.metrics {
+		m.Describe(ch)
+	}
+	s.errors.Describe(ch)
+}
+
+func (s *SecurityMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range s.metrics {
+		m.Collect(ch)
+	}
+	s.errors.Collect(ch)
+}
+
+// check interface
+var _ Collection = new(SecurityMetrics)
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+const (
+	serverQuery = `SELECT extract(epoch FROM pg_postmaster_start_time()), extract(epoch FROM pg_conf_load_time()),
+		current_setting('server_version'), pg_is_in_recovery()::int,
+		(current_setting('data_checksums') = 'on')::int`
+
+	controlQuery = `SELECT s.system_identifier, c.timeline_id, extract(epoch FROM c.checkpoint_time)
+		FROM pg_control_system() s, pg_control_checkpoint() c`
+)
+
+// ServerMetrics exports identity of the server and its lifecycle events:
+// restarts, configuration reloads, checkpoints and timeline changes on failover
+type ServerMetrics struct {
+	mutex   sync.Mutex
+	warned  bool
+	info    *prometheus.GaugeVec
+	metrics map[string]prometheus.Gauge
+	// control data metrics are vectors without labels, so they are exported
+	// only after pg_control functions were run successfully
+	control map[string]*prometheus.GaugeVec
+}
+
+func NewServerMetrics() *ServerMetrics {
+	opts := func(name, help string) prometheus.GaugeOpts {
+		return prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      name,
+			Help:      help,
+		}
+	}
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(opts(name, help))
+	}
+
+	return &ServerMetrics{
+		info: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "server",
+			Name:      "info",
+			Help:      "Server version, cluster name and system identifier",
+		}, []string{"version", "cluster_name", "system_identifier"}),
+		metrics: map[string]prometheus.Gauge{
+			"start_time":     gauge("start_time_seconds", "Time when the server started"),
+			"conf_load_time": gauge("config_load_time_seconds", "Time when the server configuration was last loaded"),
+			"version_num":    gauge("version_num", "Server version as a number, e.g. 90605 for 9.6.5 or 100001 for 10.1"),
+			"in_recovery":    gauge("in_recovery", "Whether the server is in recovery, i.e. is a standby"),
+			"data_checksums": gauge("data_checksums_enabled", "Whether data checksums are enabled"),
+		},
+		control: map[string]*prometheus.GaugeVec{
+			"timeline_id":     prometheus.NewGaugeVec(opts("timeline_id", "Timeline of the latest checkpoint, it changes on promotion"), nil),
+			"checkpoint_time": prometheus.NewGaugeVec(opts("last_checkpoint_time_seconds", "Time of the latest checkpoint"), nil),
+		},
+	}
+}
+
+func (s *ServerMetrics) Scrape(db *sql.DB) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+	s.metrics["version_num"].Set(float64(version))
+
+	var startTime, confLoadTime, inRecovery, checksums float64
+	var versionName string
+	err = db.QueryRow(serverQuery).Scan(&startTime, &confLoadTime, &versionName, &inRecovery, &checksums)
+	if err != nil {
+		return errors.New("error running server info query on database: " + err.Error())
+	}
+	s.metrics["start_time"].Set(startTime)
+	s.metrics["conf_load_time"].Set(confLoadTime)
+	s.metrics["in_recovery"].Set(inRecovery)
+	s.metrics["data_checksums"].Set(checksums)
+
+	// cluster_name appeared in 9.5
+	var clusterName string
+	if version >= 90500 {
+		err = db.QueryRow("SHOW cluster_name").Scan(&clusterName)
+		if err != nil {
+			return errors.New("error getting cluster name: " + err.Error())
+		}
+	}
+
+	// pg_control functions appeared in 9.6, system identifier doesn't fit
+	// float64, so it is exported as a label
+	for _, m := range s.control {
+		m.Reset()
+	}
+	var systemID string
+	if version >= 90600 {
+		systemID, err = s.getControlData(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.info.Reset()
+	s.info.WithLabelValues(versionName, clusterName, systemID).Set(1)
+
+	return nil
+}
+
+func (s *ServerMetrics) getControlData(db *sql.DB) (string, error) {
+	var allowed bool
+	err := db.QueryRow("SELECT has_function_privilege('pg_control_checkpoint()', 'EXECUTE') AND has_function_privilege('pg_control_system()', 'EXECUTE')").Scan(&allowed)
+	if err != nil {
+		return "", errors.New("error checking pg_control functions privilege: " + err.Error())
+	}
+	if !allowed {
+		if !s.warned {
+			log.Warn("pg_control_checkpoint() and pg_control_system() are not allowed, timeline and system identifier are not collected")
+			s.warned = true
+		}
+		return "", nil
+	}
+	s.warned = false
+
+	var systemID int64
+	var timeline, checkpointTime float64
+	err = db.QueryRow(controlQuery).Scan(&systemID, &timeline, &checkpointTime)
+	if err != nil {
+		return "", errors.New("error running control data query on database: " + err.Error())
+	}
+	s.control["timeline_id"].WithLabelValues().Set(timeline)
+	s.control["checkpoint_time"].WithLabelValues().Set(checkpointTime)
+
+	return strconv.FormatInt(systemID, 10), nil
+}
+
+func (s *ServerMetrics) Describe(ch chan<- *prometheus.Desc) {
+	s.info.Describe(ch)
+	for _, m := range s.metrics {
+		m.Describe(ch)
+	}
+	for _, m := range s.control {
+		m.Describe(ch)
+	}
+}
+
+func (s *ServerMetrics) Collect(ch chan<- prometheus.Metric) {
+	s.info.Collect(ch)
+	for _, m := range s.metrics {
+		m.Collect(ch)
+	}
+	for _, m := range s.control {
+		m.Collect(ch)
+	}
+}
+
+// check interface
+var _ Collection = new(ServerMetrics)
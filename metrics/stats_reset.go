@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statsViews are statistics views read by other collections. Queries give
+// reset time and a sum of counters, which only drops when the view is reset.
+var statsViews = []struct {
+	view       string
+	minVersion int
+	perDB      bool
+	query      string
+}{
+	{
+		view:  "pg_stat_database",
+		perDB: true,
+		query: `SELECT coalesce(extract(epoch FROM stats_reset), 0),
+			xact_commit + xact_rollback + blks_read + blks_hit + tup_returned
+			FROM pg_stat_database WHERE datname = $1`,
+	},
+	{
+		view:  "pg_stat_bgwriter",
+		query: "SELECT coalesce(extract(epoch FROM stats_reset), 0), buffers_alloc FROM pg_stat_bgwriter",
+	},
+	{
+		view:       "pg_stat_io",
+		minVersion: 160000,
+		query: `SELECT coalesce(extract(epoch FROM max(stats_reset)), 0),
+			coalesce(sum(coalesce(reads, 0) + coalesce(writes, 0) + coalesce(extends, 0) + coalesce(hits, 0)), 0)
+			FROM pg_stat_io`,
+	},
+}
+
+// statsState is reset time and counters sum of a view seen on previous scrape
+type statsState struct {
+	resetTime, counters float64
+}
+
+// StatsResetMetrics exports time of the last statistics reset of views and
+// counts resets observed between scrapes, so drops of counters caused by
+// pg_stat_reset() can be told from real ones.
+type StatsResetMetrics struct {
+	mutex     sync.Mutex
+	names     []string
+	states    map[[2]string]statsState
+	resetTime *prometheus.GaugeVec
+	observed  *prometheus.CounterVec
+}
+
+func NewStatsResetMetrics(dbNames []string) *StatsResetMetrics {
+	return &StatsResetMetrics{
+		names:  dbNames,
+		states: make(map[[2]string]statsState),
+		resetTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "stats_reset_timestamp_seconds",
+			Help:      "Time of the last statistics reset of the view, 0 if statistics were never reset",
+		}, []string{"view", "db"}),
+		observed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "stats_resets_observed_total",
+			Help:      "Number of statistics resets of the view detected by the exporter between scrapes",
+		}, []string{"view", "db"}),
+	}
+}
+
+func (s *StatsResetMetrics) Scrape(db *sql.DB) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range statsViews {
+		if version < v.minVersion {
+			continue
+		}
+
+		if !v.perDB {
+			err = s.check(db, v.view, "", v.query)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, name := range s.names {
+			err = s.check(db, v.view, name, v.query, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// check compares state of the view with previous scrape, reset time moves
+// forward or counters drop on reset
+func (s *StatsResetMetrics) check(db *sql.DB, view, dbName, query string, args ...interface{}) error {
+	var state statsState
+	err := db.QueryRow(query, args...).Scan(&state.resetTime, &state.counters)
+	if err != nil {
+		return errors.New("error getting statistics reset time of " + view + ": " + err.Error())
+	}
+	s.resetTime.WithLabelValues(view, dbName).Set(state.resetTime)
+
+	key := [2]string{view, dbName}
+	prev, ok := s.states[key]
+	if ok && (state.resetTime > prev.resetTime || state.counters < prev.counters) {
+		s.observed.WithLabelValues(view, dbName).Inc()
+	} else {
+		// make the series visible before the first reset
+		s.observed.WithLabelValues(view, dbName)
+	}
+	s.states[key] = state
+
+	return nil
+}
+
+func (s *StatsResetMetrics) Describe(ch chan<- *prometheus.Desc) {
+	s.resetTime.Describe(ch)
+	s.observed.Describe(ch)
+}
+
+func (s *StatsResetMetrics) Collect(ch chan<- prometheus.Metric) {
+	s.resetTime.Collect(ch)
+	s.observed.Collect(ch)
+}
+
+// check interface
+var _ Collection = new(StatsResetMetrics)
@@ -24,21 +24,97 @@ var (
 		"n_live_tup":        metric{Name: "n_live_tup_total", Help: "Estimated number of live rows"},
 		"n_dead_tup":        metric{Name: "n_dead_tup_total", Help: "Estimated number of dead rows"},
 	}
+
+	tableSizeMetrics = map[string]metric{
+		"main_size":    metric{Name: "main_size_bytes", Help: "Size of the main data fork of the table"},
+		"toast_size":   metric{Name: "toast_size_bytes", Help: "Size of TOAST table of the table including its index"},
+		"indexes_size": metric{Name: "indexes_size_bytes", Help: "Total size of indexes of the table"},
+		"fsm_size":     metric{Name: "fsm_size_bytes", Help: "Size of the free space map fork of the table"},
+		"vm_size":      metric{Name: "vm_size_bytes", Help: "Size of the visibility map fork of the table"},
+		"relpages":     metric{Name: "pages", Help: "Number of pages of the table estimated by VACUUM and ANALYZE"},
+	}
+
+	// tableSizeExprs are expressions of tableSizeMetrics over pg_class c
+	tableSizeExprs = map[string]string{
+		"main_size":    "pg_relation_size(c.oid, 'main')",
+		"toast_size":   "coalesce(pg_total_relation_size(nullif(c.reltoastrelid, 0)), 0)",
+		"indexes_size": "pg_indexes_size(c.oid)",
+		"fsm_size":     "pg_relation_size(c.oid, 'fsm')",
+		"vm_size":      "pg_relation_size(c.oid, 'vm')",
+		"relpages":     "c.relpages",
+	}
+
+	tableIOMetrics = map[string]metric{
+		"heap_blks_read":  metric{Name: "heap_blks_read_total", Help: "Number of disk blocks read from this table"},
+		"heap_blks_hit":   metric{Name: "heap_blks_hit_total", Help: "Number of buffer hits in this table"},
+		"idx_blks_read":   metric{Name: "idx_blks_read_total", Help: "Number of disk blocks read from all indexes on this table"},
+		"idx_blks_hit":    metric{Name: "idx_blks_hit_total", Help: "Number of buffer hits in all indexes on this table"},
+		"toast_blks_read": metric{Name: "toast_blks_read_total", Help: "Number of disk blocks read from this table's TOAST table"},
+		"toast_blks_hit":  metric{Name: "toast_blks_hit_total", Help: "Number of buffer hits in this table's TOAST table"},
+		"tidx_blks_read":  metric{Name: "tidx_blks_read_total", Help: "Number of disk blocks read from this table's TOAST table indexes"},
+		"tidx_blks_hit":   metric{Name: "tidx_blks_hit_total", Help: "Number of buffer hits in this table's TOAST table indexes"},
+	}
 )
 
-type TableMetrics struct {
-	mutex    sync.Mutex
+// tableSelection is the set of tables tracked by table level collections.
+// A single "*" entry means all tables of the public schema.
+type tableSelection struct {
 	names    []string
 	namesMap map[string]struct{}
-	metrics  map[string]*prometheus.GaugeVec
 }
 
-func NewTableMetrics(tableNames []string) *TableMetrics {
+func newTableSelection(tableNames []string) *tableSelection {
 	namesMap := make(map[string]struct{})
 	for _, name := range tableNames {
 		namesMap[name] = struct{}{}
 	}
 
+	return &tableSelection{
+		names:    tableNames,
+		namesMap: namesMap,
+	}
+}
+
+func (s *tableSelection) resolve(db *sql.DB) error {
+	if len(s.names) == 1 && s.names[0] == "*" {
+		// we will get all tables only once on first scrape
+		// so don't forget to restart exporter after adding/removing tables
+		names, err := getAllTablesForDB(db)
+		if err != nil {
+			return err
+		}
+		namesMap := make(map[string]struct{})
+		for _, name := range names {
+			namesMap[name] = struct{}{}
+		}
+		s.names = names
+		s.namesMap = namesMap
+	}
+
+	return nil
+}
+
+func (s *tableSelection) contains(name string) bool {
+	_, ok := s.namesMap[name]
+	return ok
+}
+
+type TableMetrics struct {
+	mutex   sync.Mutex
+	tables  *tableSelection
+	metrics map[string]*prometheus.GaugeVec
+	// heap blocks of previous scrape by table for windowed cache hit ratio
+	blocks map[string]blockCounters
+	// partitions are summed up into their roots when not nil
+	partitions *partitions
+}
+
+// NewTableMetrics creates table collection, with windowedRatio cache hit
+// ratio is also computed from blocks accessed between scrapes. With
+// partitionRollup statistics of partitions are summed up into the root of
+// partitioning tree and only keepPartitions newest partitions of each root
+// are exported separately.
+func NewTableMetrics(tableNames []string, windowedRatio, partitionRollup bool, keepPartitions int) *TableMetrics {
 	metrics := map[string]*prometheus.GaugeVec{
 		"table_cache_hit_ratio": prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
@@ -50,7 +126,13 @@ func NewTableMetrics(tableNames []string) *TableMetrics {
 			Namespace: namespace,
 			Subsystem: "tables",
 			Name:      "items_count_total",
-			Help:      "Table items count",
+			Help:      "Table items count estimated by VACUUM and ANALYZE",
+		}, []string{"table"}),
+		"table_all_visible": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "tables",
+			Name:      "all_visible_ratio",
+			Help:      "Fraction of table pages marked all-visible in the visibility map",
 		}, []string{"table"}),
 		"table_size": prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
@@ -60,42 +142,59 @@ func NewTableMetrics(tableNames []string) *TableMetrics {
 		}, []string{"table"}),
 	}
 
-	for name, metric := range tableMetrics {
-		metrics[name] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	for _, defs := range []map[string]metric{tableMetrics, tableSizeMetrics, tableIOMetrics} {
+		for name, metric := range defs {
+			metrics[name] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "tables",
+				Name:      metric.Name,
+				Help:      metric.Help,
+			}, []string{"table"})
+		}
+	}
+
+	t := &TableMetrics{
+		tables:  newTableSelection(tableNames),
+		metrics: metrics,
+	}
+
+	if partitionRollup {
+		t.partitions = newPartitions(keepPartitions)
+	}
+
+	if windowedRatio {
+		t.blocks = make(map[string]blockCounters)
+		t.metrics["table_windowed_cache_hit_ratio"] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Subsystem: "tables",
-			Name:      metric.Name,
-			Help:      metric.Help,
+			Name:      "windowed_cache_hit_ratio_percent",
+			Help:      "Table cache hit ratio of blocks accessed since previous scrape",
 		}, []string{"table"})
 	}
 
-	return &TableMetrics{
-		names:    tableNames,
-		namesMap: namesMap,
-		metrics:  metrics,
-	}
+	return t
 }
 
 func (t *TableMetrics) Scrape(db *sql.DB) error {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	if len(t.names) == 1 && t.names[0] == "*" {
-		// we will get all tables only once on first scrape
-		// so don't forget to restart exporter after adding/removing tables
-		names, err := t.getAllTablesForDB(db)
+	if err := t.tables.resolve(db); err != nil {
+		return nil
+	}
+
+	if t.partitions != nil {
+		err := t.partitions.load(db)
 		if err != nil {
-			return nil
+			return err
 		}
-		namesMap := make(map[string]struct{})
-		for _, name := range names {
-			namesMap[name] = struct{}{}
+		// the set of detailed partitions changes over time
+		for _, m := range t.metrics {
+			m.Reset()
 		}
-		t.names = names
-		t.namesMap = namesMap
 	}
 
-	err := t.getCacheRatio(db)
+	err := t.getTableIO(db)
 	if err != nil {
 		return err
 	}
@@ -120,93 +219,220 @@ func (t *TableMetrics) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+// series returns table labels statistics of the table are added to: the
+// table itself, or the root of its partitioning tree and the partition when
+// it is one of the newest
+func (t *TableMetrics) series(name string) []string {
+	root, ok := "", false
+	if t.partitions != nil {
+		root, ok = t.partitions.roots[name]
+	}
+	if !ok {
+		if t.tables.contains(name) {
+			return []string{name}
+		}
+		return nil
+	}
+
+	var labels []string
+	if t.tables.contains(root) {
+		labels = append(labels, root)
+	}
+	if _, detailed := t.partitions.detailed[name]; detailed || (t.tables.contains(name) && !t.tables.contains(root)) {
+		labels = append(labels, name)
+	}
+
+	return labels
+}
+
+// addSeries sums values of the table up into its series
+func (t *TableMetrics) addSeries(sums map[string][]float64, name string, vals []float64) {
+	for _, label := range t.series(name) {
+		sum, ok := sums[label]
+		if !ok {
+			sum = make([]float64, len(vals))
+			sums[label] = sum
+		}
+		for i, val := range vals {
+			sum[i] += val
+		}
+	}
+}
+
 func (t *TableMetrics) getTableMetrics(db *sql.DB) error {
-	selectClause := []string{"relname"}
-	for col := range tableMetrics {
-		selectClause = append(selectClause, col)
+	cols := wantedColumns("tables", tableMetrics)
+	if len(cols) == 0 {
+		return nil
 	}
 
-	query := "SELECT " + strings.Join(selectClause, ", ") + " FROM pg_stat_user_tables WHERE schemaname = $1"
+	query := "SELECT relname, " + strings.Join(cols, ", ") + " FROM pg_stat_user_tables WHERE schemaname = $1"
 	rows, err := db.Query(query, "public")
 	if err != nil {
-		return err
+		return errors.New("error running table stats query on database: " + err.Error())
 	}
 	defer rows.Close()
 
+	sums := make(map[string][]float64)
 	for rows.Next() {
 		var name string
 		args := []interface{}{&name}
-		vals := make([]float64, len(tableMetrics))
+		vals := make([]float64, len(cols))
 		for i := range vals {
 			args = append(args, &vals[i])
 		}
 		err = rows.Scan(args...)
 		if err != nil {
-			return err
+			return errors.New("error running table stats query on database: " + err.Error())
 		}
 
-		for i, col := range selectClause {
-			t.metrics[col].WithLabelValues(name).Set(vals[i])
+		// process only selected tables
+		t.addSeries(sums, name, vals)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for label, vals := range sums {
+		for i, col := range cols {
+			t.metrics[col].WithLabelValues(label).Set(vals[i])
 		}
 	}
 
-	return rows.Err()
+	return nil
 }
 
+// getTableSizes exports total size of tables, its breakdown by forks, TOAST
+// and indexes, and planner estimates of pages and rows
 func (t *TableMetrics) getTableSizes(db *sql.DB) error {
-	query := "SELECT table_name, pg_total_relation_size(table_name) FROM information_schema.tables WHERE table_schema='public'"
-	rows, err := db.Query(query)
+	cols := wantedColumns("tables", tableSizeMetrics)
+	total := wanted("tables", "size_bytes")
+	items := wanted("tables", "items_count_total")
+	allVisible := wanted("tables", "all_visible_ratio")
+	if len(cols) == 0 && !total && !items && !allVisible {
+		return nil
+	}
+
+	// reltuples is -1 for tables which were never vacuumed or analyzed since 14
+	selectClause := []string{"c.relname", "pg_total_relation_size(c.oid)", "greatest(c.reltuples, 0)", "c.relpages", "c.relallvisible"}
+	for _, col := range cols {
+		selectClause = append(selectClause, tableSizeExprs[col])
+	}
+
+	query := "SELECT " + strings.Join(selectClause, ", ") +
+		" FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace" +
+		" WHERE n.nspname = $1 AND c.relkind IN ('r', 'p', 'm', 'f')"
+	rows, err := db.Query(query, "public")
 	if err != nil {
-		return err
+		return errors.New("error running table sizes query on database: " + err.Error())
 	}
 	defer rows.Close()
 
+	// fixed columns go first, so they are summed up with other columns
+	sums := make(map[string][]float64)
 	for rows.Next() {
 		var name string
-		var size float64
-		err = rows.Scan(&name, &size)
+		vals := make([]float64, len(cols)+4)
+		args := []interface{}{&name}
+		for i := range vals {
+			args = append(args, &vals[i])
+		}
+		err = rows.Scan(args...)
 		if err != nil {
-			return err
+			return errors.New("error running table sizes query on database: " + err.Error())
 		}
 
-		if _, ok := t.namesMap[name]; !ok {
-			// process only selected tables
-			continue
+		// process only selected tables
+		t.addSeries(sums, name, vals)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for label, vals := range sums {
+		if total {
+			t.metrics["table_size"].WithLabelValues(label).Set(vals[0])
+		}
+		if items {
+			t.metrics["table_items_count"].WithLabelValues(label).Set(vals[1])
+		}
+		if allVisible && vals[2] > 0 {
+			t.metrics["table_all_visible"].WithLabelValues(label).Set(vals[3] / vals[2])
+		}
+		for i, col := range cols {
+			t.metrics[col].WithLabelValues(label).Set(vals[i+4])
 		}
-		t.metrics["table_size"].WithLabelValues(name).Set(size)
 	}
 
-	return rows.Err()
+	return nil
 }
 
-func (t *TableMetrics) getCacheRatio(db *sql.DB) error {
-	query := "SELECT relname, round(heap_blks_hit*100/(heap_blks_hit+heap_blks_read), 2) AS cache_hit_ratio" +
-		" FROM pg_statio_user_tables WHERE heap_blks_read > 0"
-	rows, err := db.Query(query)
+// getTableIO exports block counters of tables and cache hit ratios computed from heap blocks
+func (t *TableMetrics) getTableIO(db *sql.DB) error {
+	cols := wantedColumns("tables", tableIOMetrics)
+	ratio := wanted("tables", "cache_hit_ratio_percent")
+	windowed := t.blocks != nil && wanted("tables", "windowed_cache_hit_ratio_percent")
+	if len(cols) == 0 && !ratio && !windowed {
+		return nil
+	}
+
+	// toast columns are null for tables without TOAST table
+	selectClause := []string{"relname", "heap_blks_hit", "heap_blks_read"}
+	for _, col := range cols {
+		selectClause = append(selectClause, "coalesce("+col+", 0)")
+	}
+
+	query := "SELECT " + strings.Join(selectClause, ", ") + " FROM pg_statio_user_tables WHERE schemaname = $1"
+	rows, err := db.Query(query, "public")
 	if err != nil {
 		return errors.New("error running table cache hit stats query on database: " + err.Error())
 	}
 	defer rows.Close()
 
+	// heap blocks go first, so they are summed up with other columns
+	sums := make(map[string][]float64)
 	for rows.Next() {
 		var name string
-		var ratio float64
-		err := rows.Scan(&name, &ratio)
+		vals := make([]float64, len(cols)+2)
+		args := []interface{}{&name}
+		for i := range vals {
+			args = append(args, &vals[i])
+		}
+		err := rows.Scan(args...)
 		if err != nil {
 			return errors.New("error running table cache hit stats query on database: " + err.Error())
 		}
 
-		if _, ok := t.namesMap[name]; !ok {
-			// process only selected tables
-			continue
+		// process only selected tables
+		t.addSeries(sums, name, vals)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for label, vals := range sums {
+		blocks := blockCounters{hit: vals[0], read: vals[1]}
+		for i, col := range cols {
+			t.metrics[col].WithLabelValues(label).Set(vals[i+2])
+		}
+		if ratio && blocks.hit+blocks.read > 0 {
+			t.metrics["table_cache_hit_ratio"].WithLabelValues(label).Set(blocks.ratio())
+		}
+		if windowed {
+			setWindowedRatio(t.metrics["table_windowed_cache_hit_ratio"], label, t.blocks[label], blocks)
+			t.blocks[label] = blocks
+		}
+	}
+	// forget dropped tables and partitions which are not detailed anymore
+	for label := range t.blocks {
+		if _, ok := sums[label]; !ok {
+			delete(t.blocks, label)
 		}
-		t.metrics["table_cache_hit_ratio"].WithLabelValues(name).Set(ratio)
 	}
 
-	return rows.Err()
+	return nil
 }
 
-func (t *TableMetrics) getAllTablesForDB(db *sql.DB) ([]string, error) {
+func getAllTablesForDB(db *sql.DB) ([]string, error) {
 	// get all tables from database and cache them
 	// it will happen only first scrape
 	rows, err := db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema='public'")
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+const waitEventQuery = `SELECT coalesce(datname, ''), coalesce(state, ''),
+		coalesce(wait_event_type, ''), coalesce(wait_event, ''), count(*)
+	FROM pg_stat_activity
+	WHERE pid <> pg_backend_pid()
+	GROUP BY 1, 2, 3, 4`
+
+// WaitEventMetrics samples wait events of pg_stat_activity in background
+// between scrapes. Sampling is started on first scrape and uses a single
+// goroutine, so at most one sampling query is running at a time; ticks are
+// skipped while the previous query is still in progress.
+type WaitEventMetrics struct {
+	once     sync.Once
+	interval time.Duration
+	samples  *prometheus.CounterVec
+	total    prometheus.Counter
+}
+
+func NewWaitEventMetrics(interval time.Duration) *WaitEventMetrics {
+	return &WaitEventMetrics{
+		interval: interval,
+		samples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "wait_events",
+			Name:      "samples_total",
+			Help:      "Number of backends seen in given wait event and state when sampling pg_stat_activity",
+		}, []string{"db", "state", "wait_event_type", "wait_event"}),
+		total: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "wait_events",
+			Name:      "sampling_rounds_total",
+			Help:      "Number of times pg_stat_activity was sampled",
+		}),
+	}
+}
+
+func (w *WaitEventMetrics) Scrape(db *sql.DB) error {
+	w.once.Do(func() {
+		go w.sample(db)
+	})
+
+	return nil
+}
+
+func (w *WaitEventMetrics) sample(db *sql.DB) {
+	version, err := serverVersion(db)
+	for err != nil {
+		log.Errorf("wait event sampling: %s", err)
+		time.Sleep(time.Minute)
+		version, err = serverVersion(db)
+	}
+	if version < 90600 {
+		log.Warn("wait event sampling requires PostgreSQL 9.6 or later")
+		return
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	failing := false
+	for range ticker.C {
+		err := w.sampleOnce(db)
+		if err != nil && !failing {
+			// log only the first error of a series, sampling is too frequent
+			log.Errorf("wait event sampling: %s", err)
+		}
+		failing = err != nil
+	}
+}
+
+func (w *WaitEventMetrics) sampleOnce(db *sql.DB) error {
+	rows, err := db.Query(waitEventQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, state, eventType, event string
+		var count float64
+		err = rows.Scan(&name, &state, &eventType, &event, &count)
+		if err != nil {
+			return err
+		}
+		w.samples.WithLabelValues(name, state, eventType, event).Add(count)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	w.total.Inc()
+
+	return nil
+}
+
+func (w *WaitEventMetrics) Describe(ch chan<- *prometheus.Desc) {
+	w.samples.Describe(ch)
+	w.total.Describe(ch)
+}
+
+func (w *WaitEventMetrics) Collect(ch chan<- prometheus.Metric) {
+	w.samples.Collect(ch)
+	w.total.Collect(ch)
+}
+
+// check interface
+var _ Collection = new(WaitEventMetrics)
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const xminHoldersQuery = `SELECT 'backend', coalesce(max(greatest(age(backend_xmin), age(backend_xid))), 0)
+		FROM pg_stat_activity WHERE pid <> pg_backend_pid()
+	UNION ALL
+	SELECT 'replication_slot', coalesce(max(greatest(age(xmin), age(catalog_xmin))), 0)
+		FROM pg_replication_slots
+	UNION ALL
+	SELECT 'prepared_xact', coalesce(max(age(transaction)), 0)
+		FROM pg_prepared_xacts`
+
+// XactMetrics reports prepared transactions and the oldest xmin holders,
+// which prevent vacuum from removing dead rows.
+type XactMetrics struct {
+	mutex          sync.Mutex
+	metrics        map[string]*prometheus.GaugeVec
+	oldestPrepared prometheus.Gauge
+}
+
+func NewXactMetrics() *XactMetrics {
+	return &XactMetrics{
+		metrics: map[string]*prometheus.GaugeVec{
+			"prepared_count": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "prepared_xacts",
+				Name:      "count",
+				Help:      "Number of prepared transactions",
+			}, []string{"db", "owner"}),
+			"xmin_oldest_age": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "xmin",
+				Name:      "oldest_age",
+				Help:      "Age in transactions of the oldest xmin held by backends, replication slots or prepared transactions",
+			}, []string{"kind"}),
+		},
+		oldestPrepared: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "prepared_xacts",
+			Name:      "oldest_age_seconds",
+			Help:      "Age of the oldest prepared transaction",
+		}),
+	}
+}
+
+func (x *XactMetrics) Scrape(db *sql.DB) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	rows, err := db.Query("SELECT database, owner, count(*) FROM pg_prepared_xacts GROUP BY database, owner")
+	if err != nil {
+		return errors.New("error running prepared transactions query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	x.metrics["prepared_count"].Reset()
+	for rows.Next() {
+		var name, owner string
+		var count float64
+		err = rows.Scan(&name, &owner, &count)
+		if err != nil {
+			return errors.New("error running prepared transactions query on database: " + err.Error())
+		}
+		x.metrics["prepared_count"].WithLabelValues(name, owner).Set(count)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	var age float64
+	err = db.QueryRow("SELECT coalesce(extract(epoch FROM now() - min(prepared)), 0) FROM pg_prepared_xacts").Scan(&age)
+	if err != nil {
+		return errors.New("error getting oldest prepared transaction age: " + err.Error())
+	}
+	x.oldestPrepared.Set(age)
+
+	version, err := serverVersion(db)
+	if err != nil {
+		return err
+	}
+	if version < 90400 {
+		// backend_xmin and replication slots are available since 9.4
+		return nil
+	}
+
+	return x.getXminHolders(db)
+}
+
+func (x *XactMetrics) getXminHolders(db *sql.DB) error {
+	rows, err := db.Query(xminHoldersQuery)
+	if err != nil {
+		return errors.New("error running xmin holders query on database: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var age float64
+		err = rows.Scan(&kind, &age)
+		if err != nil {
+			return errors.New("error running xmin holders query on database: " + err.Error())
+		}
+		x.metrics["xmin_oldest_age"].WithLabelValues(kind).Set(age)
+	}
+
+	return rows.Err()
+}
+
+func (x *XactMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range x.metrics {
+		m.Describe(ch)
+	}
+	x.oldestPrepared.Describe(ch)
+}
+
+func (x *XactMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range x.metrics {
+		m.Collect(ch)
+	}
+	x.oldestPrepared.Collect(ch)
+}
+
+// check interface
+var _ Collection = new(XactMetrics)
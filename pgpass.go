@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/log"
+)
+
+// libpqOnlyEnv are variables which are handled by the exporter, lib/pq
+// refuses to connect when any of them is set
+var libpqOnlyEnv = []string{"PGPASSFILE", "PGSERVICE", "PGSERVICEFILE", "PGSYSCONFDIR"}
+
+var (
+	clientEnvMutex sync.Mutex
+	clientEnv      = make(map[string]string)
+)
+
+// takeClientEnv moves libpqOnlyEnv variables from environment, so lib/pq
+// doesn't see them. It is called once at start before any pool is opened.
+func takeClientEnv() {
+	clientEnvMutex.Lock()
+	defer clientEnvMutex.Unlock()
+	for _, name := range libpqOnlyEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			clientEnv[name] = value
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// clientGetenv returns variable taken by takeClientEnv
+func clientGetenv(name string) string {
+	clientEnvMutex.Lock()
+	defer clientEnvMutex.Unlock()
+
+	return clientEnv[name]
+}
+
+// homeFile returns path of file in home directory of current user
+func homeFile(name string) string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		if u, err := user.Current(); err == nil {
+			home = u.HomeDir
+		}
+	}
+	if home == "" {
+		return ""
+	}
+
+	return filepath.Join(home, name)
+}
+
+// connectionParam returns connection parameter from options, environment
+// or libpq default, it is used to match pgpass entries
+func connectionParam(opts map[string]string, key string) string {
+	if v := opts[key]; v != "" {
+		return v
+	}
+
+	env := map[string]string{
+		"host":   "PGHOST",
+		"port":   "PGPORT",
+		"dbname": "PGDATABASE",
+		"user":   "PGUSER",
+	}
+	if v := os.Getenv(env[key]); v != "" {
+		return v
+	}
+
+	switch key {
+	case "host":
+		return "localhost"
+	case "port":
+		return "5432"
+	case "user":
+		if u, err := user.Current(); err == nil {
+			return u.Username
+		}
+	case "dbname":
+		return connectionParam(opts, "user")
+	}
+
+	return ""
+}
+
+// pgpassPassword looks up password in PGPASSFILE or ~/.pgpass like libpq does
+func pgpassPassword(opts map[string]string) string {
+	path := clientGetenv("PGPASSFILE")
+	if path == "" {
+		path = homeFile(".pgpass")
+	}
+	if path == "" {
+		return ""
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	if runtime.GOOS != "windows" {
+		fi, err := f.Stat()
+		if err != nil {
+			return ""
+		}
+		if fi.Mode().Perm()&0077 != 0 {
+			log.Warnf("password file %s has group or world access, permissions should be u=rw (0600) or less", path)
+			return ""
+		}
+	}
+
+	host := connectionParam(opts, "host")
+	if strings.HasPrefix(host, "/") {
+		// unix socket directory matches localhost entries
+		host = "localhost"
+	}
+	want := []string{
+		host,
+		connectionParam(opts, "port"),
+		connectionParam(opts, "dbname"),
+		connectionParam(opts, "user"),
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+
+		matched := true
+		for i, w := range want {
+			if fields[i] != "*" && fields[i] != w {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return fields[4]
+		}
+	}
+
+	return ""
+}
+
+// splitPgpassLine splits pgpass line by colons, handling \: and \\ escapes
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var field []rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			field = append(field, r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ':':
+			fields = append(fields, string(field))
+			field = nil
+		default:
+			field = append(field, r)
+		}
+	}
+
+	return append(fields, string(field))
+}
+
+// serviceParams returns connection parameters of service from PGSERVICEFILE
+// or ~/.pg_service.conf, falling back to pg_service.conf in PGSYSCONFDIR.
+func serviceParams(service string) (map[string]string, bool) {
+	var paths []string
+	if path := clientGetenv("PGSERVICEFILE"); path != "" {
+		paths = append(paths, path)
+	} else if path := homeFile(".pg_service.conf"); path != "" {
+		paths = append(paths, path)
+	}
+	if dir := clientGetenv("PGSYSCONFDIR"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "pg_service.conf"))
+	}
+
+	for _, path := range paths {
+		if params, ok := readServiceFile(path, service); ok {
+			return params, true
+		}
+	}
+
+	return nil, false
+}
+
+func readServiceFile(path, service string) (map[string]string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var params map[string]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if params != nil {
+				// the service section is over
+				return params, true
+			}
+			if line[1:len(line)-1] == service {
+				params = make(map[string]string)
+			}
+			continue
+		}
+
+		if params == nil {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 {
+			params[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	return params, params != nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitPgpassLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		fields []string
+	}{
+		{"localhost:5432:app:user:secret", []string{"localhost", "5432", "app", "user", "secret"}},
+		{"*:*:*:*:secret", []string{"*", "*", "*", "*", "secret"}},
+		{`host:5432:app:user:pass\:word`, []string{"host", "5432", "app", "user", "pass:word"}},
+		{`host:5432:app:user:back\\slash`, []string{"host", "5432", "app", "user", `back\slash`}},
+		{`host:5432:app:user:ends\\:extra`, []string{"host", "5432", "app", "user", `ends\`, "extra"}},
+		{"host:5432:app:user:", []string{"host", "5432", "app", "user", ""}},
+		{"host:5432", []string{"host", "5432"}},
+	}
+
+	for _, test := range tests {
+		if fields := splitPgpassLine(test.line); !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%q: expected %q, got %q", test.line, test.fields, fields)
+		}
+	}
+}
+
+func TestPgpassPassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pgpass")
+	content := `# comment
+db.example.com:5432:app:reader:exact
+db.example.com:*:*:reader:any-port
+localhost:5432:*:local:socket
+*:*:*:escaped:pass\:word
+db.example.com:5432:app:reader:shadowed
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setClientEnv(t, map[string]string{"PGPASSFILE": path})
+
+	tests := []struct {
+		opts     map[string]string
+		password string
+	}{
+		// the first matching line wins
+		{map[string]string{"host": "db.example.com", "port": "5432", "dbname": "app", "user": "reader"}, "exact"},
+		{map[string]string{"host": "db.example.com", "port": "6432", "dbname": "app", "user": "reader"}, "any-port"},
+		{map[string]string{"host": "/var/run/postgresql", "port": "5432", "dbname": "app", "user": "local"}, "socket"},
+		{map[string]string{"host": "other", "port": "5432", "dbname": "app", "user": "escaped"}, "pass:word"},
+		{map[string]string{"host": "other", "port": "5432", "dbname": "app", "user": "reader"}, ""},
+	}
+
+	for _, test := range tests {
+		if password := pgpassPassword(test.opts); password != test.password {
+			t.Errorf("%v: expected %q, got %q", test.opts, test.password, password)
+		}
+	}
+}
+
+func TestPgpassPasswordPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pgpass")
+	if err := ioutil.WriteFile(path, []byte("*:*:*:*:secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setClientEnv(t, map[string]string{"PGPASSFILE": path})
+
+	// libpq ignores password file readable by others
+	if password := pgpassPassword(map[string]string{"user": "x"}); password != "" {
+		t.Fatalf("password file with group or world access must be ignored, got %q", password)
+	}
+}
+
+func TestReadServiceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg_service.conf")
+	content := `# comment
+[first]
+host=first.example.com
+
+[main]
+  host = db.example.com
+port=6432
+# comment inside section
+options=-c statement_timeout=5s
+invalid line
+
+[last]
+host=last.example.com
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		service string
+		params  map[string]string
+		ok      bool
+	}{
+		{"main", map[string]string{"host": "db.example.com", "port": "6432", "options": "-c statement_timeout=5s"}, true},
+		{"last", map[string]string{"host": "last.example.com"}, true},
+		{"missing", nil, false},
+	}
+
+	for _, test := range tests {
+		params, ok := readServiceFile(path, test.service)
+		if ok != test.ok || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected %v %t, got %v %t", test.service, test.params, test.ok, params, ok)
+		}
+	}
+
+	if _, ok := readServiceFile(filepath.Join(t.TempDir(), "missing.conf"), "main"); ok {
+		t.Error("missing file must have no services")
+	}
+}
+
+func TestServiceParamsFallback(t *testing.T) {
+	dir := t.TempDir()
+	userFile := filepath.Join(dir, "user.conf")
+	if err := ioutil.WriteFile(userFile, []byte("[user]\nhost=user.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pg_service.conf"), []byte("[system]\nhost=system.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setClientEnv(t, map[string]string{"PGSERVICEFILE": userFile, "PGSYSCONFDIR": dir})
+
+	// user file takes precedence, system one is used for other services
+	for service, host := range map[string]string{"user": "user.example.com", "system": "system.example.com"} {
+		params, ok := serviceParams(service)
+		if !ok || params["host"] != host {
+			t.Errorf("%s: expected host %s, got %v %t", service, host, params, ok)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolStats exports how the exporter uses its own connections, pools of
+// monitored databases used for catalog queries are labeled by db
+type poolStats struct {
+	maxOpen, open, inUse, idle, waitCount, waitDuration, closed *prometheus.Desc
+}
+
+func newPoolStats() *poolStats {
+	return &poolStats{
+		maxOpen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter_pool", "max_open_connections"),
+			"Maximum number of open connections to the target.",
+			[]string{"db"}, nil,
+		),
+		open: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter_pool", "open_connections"),
+			"Number of established connections both in use and idle.",
+			[]string{"db"}, nil,
+		),
+		inUse: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter_pool", "in_use_connections"),
+			"Number of connections currently in use.",
+			[]string{"db"}, nil,
+		),
+		idle: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter_pool", "idle_connections"),
+			"Number of idle connections.",
+			[]string{"db"}, nil,
+		),
+		waitCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter_pool", "wait_count_total"),
+			"Total number of connections waited for.",
+			[]string{"db"}, nil,
+		),
+		waitDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter_pool", "wait_duration_seconds_total"),
+			"Total time blocked waiting for a new connection.",
+			[]string{"db"}, nil,
+		),
+		closed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter_pool", "closed_total"),
+			"Total number of connections closed by reason: max_idle, max_idle_time or max_lifetime.",
+			[]string{"db", "reason"}, nil,
+		),
+	}
+}
+
+func (p *poolStats) describe(ch chan<- *prometheus.Desc) {
+	ch <- p.maxOpen
+	ch <- p.open
+	ch <- p.inUse
+	ch <- p.idle
+	ch <- p.waitCount
+	ch <- p.waitDuration
+	ch <- p.closed
+}
+
+func (p *poolStats) collect(db *sql.DB, dbName string, ch chan<- prometheus.Metric) {
+	stats := db.Stats()
+
+	ch <- prometheus.MustNewConstMetric(p.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections), dbName)
+	ch <- prometheus.MustNewConstMetric(p.open, prometheus.GaugeValue, float64(stats.OpenConnections), dbName)
+	ch <- prometheus.MustNewConstMetric(p.inUse, prometheus.GaugeValue, float64(stats.InUse), dbName)
+	ch <- prometheus.MustNewConstMetric(p.idle, prometheus.GaugeValue, float64(stats.Idle), dbName)
+	ch <- prometheus.MustNewConstMetric(p.waitCount, prometheus.CounterValue, float64(stats.WaitCount), dbName)
+	ch <- prometheus.MustNewConstMetric(p.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds(), dbName)
+	ch <- prometheus.MustNewConstMetric(p.closed, prometheus.CounterValue, float64(stats.MaxIdleClosed), dbName, "max_idle")
+	ch <- prometheus.MustNewConstMetric(p.closed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed), dbName, "max_idle_time")
+	ch <- prometheus.MustNewConstMetric(p.closed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), dbName, "max_lifetime")
+}
@@ -1,81 +1,116 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	_ "github.com/lib/pq"
-
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/log"
 
 	"github.com/mc2soft/postgresql_exporter/metrics"
 )
 
-const (
-	namespace = "postgresql"
-)
+// namespace is a prefix of metric names
+var namespace = "postgresql"
 
-var db *sql.DB
+// shutdownTimeout limits waiting for running scrapes on shutdown
+const shutdownTimeout = 10 * time.Second
 
 var (
-	listenAddress = flag.String("web.listen-address", ":9104", "Address to listen on for web interface and telemetry.")
-	metricPath    = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-	databases     = flag.String("db.names", "", "Comma-separated list of monitored DB.")
-	slow          = flag.Duration("db.consider-query-slow", 100*time.Millisecond, "Queries with execution time higher than this value will be considered as slow (e.g. 100ms, 1s).")
-	tables        = flag.String("db.tables", "", "Comma-separated list of tables to track.")
-	queries       = flag.String("queries.config-path", "", "Path to yaml files with custom queries")
+	listenAddress    = flag.String("web.listen-address", ":9104", "Address to listen on for web interface and telemetry.")
+	metricPath       = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	readyTimeout     = flag.Duration("web.ready-timeout", 3*time.Second, "Timeout of connection check of each target in readiness endpoint.")
+	databases        = flag.String("db.names", "", "Comma-separated list of monitored DB.")
+	slow             = flag.Duration("db.consider-query-slow", 100*time.Millisecond, "Queries with execution time higher than this value will be considered as slow (e.g. 100ms, 1s).")
+	tables           = flag.String("db.tables", "", "Comma-separated list of tables to track.")
+	partitionRollup  = flag.Bool("db.tables-partition-rollup", false, "Sum up statistics of partitions into the root of partitioning tree.")
+	keepPartitions   = flag.Int("db.tables-keep-partitions", 0, "Number of newest partitions of each partitioned table exported separately with db.tables-partition-rollup.")
+	windowedRatio    = flag.Bool("db.windowed-cache-hit-ratio", false, "Also export cache hit ratios of blocks accessed between scrapes.")
+	bloat            = flag.Bool("db.bloat", false, "Estimate bloat of tracked tables and their indexes.")
+	bloatExact       = flag.Bool("db.bloat-exact", false, "Use pgstattuple_approx for table bloat when pgstattuple extension is installed.")
+	bloatInterval    = flag.Duration("db.bloat-interval", time.Hour, "Interval between bloat estimations (e.g. 30m, 1h).")
+	schema           = flag.Bool("db.schema", false, "Fingerprint schema of monitored databases.")
+	schemaInterval   = flag.Duration("db.schema-interval", 10*time.Minute, "Interval between schema fingerprints (e.g. 1m, 1h).")
+	extensions       = flag.Bool("db.extensions", false, "Report extensions installed in monitored databases, a connection to each of them is kept.")
+	functions        = flag.Bool("db.functions", false, "Collect user function stats.")
+	funcInclude      = flag.String("db.functions-include", "", "Regexp for schema.function names to collect stats for, all by default.")
+	funcExclude      = flag.String("db.functions-exclude", "", "Regexp for schema.function names to skip.")
+	funcLimit        = flag.Int("db.functions-limit", 100, "Collect stats only for top N functions by total time, 0 means no limit.")
+	waitSampling     = flag.Duration("db.wait-sampling-interval", 0, "Interval between wait event samples taken in background (e.g. 100ms), 0 disables sampling.")
+	maxOpenConns     = flag.Int("db.max-open-conns", 5, "Maximum number of open connections to each target.")
+	maxIdleConns     = flag.Int("db.max-idle-conns", 5, "Maximum number of idle connections to each target.")
+	connMaxLifetime  = flag.Duration("db.conn-max-lifetime", 0, "Maximum time a connection may be reused, 0 means forever.")
+	connMaxIdleTime  = flag.Duration("db.conn-max-idle-time", 0, "Maximum time a connection may be idle, 0 means forever.")
+	heartbeatTable   = flag.String("db.heartbeat-table", "", "Table to write heartbeat into on primary and read it from on standby, disabled by default.")
+	heartbeatID      = flag.String("db.heartbeat-id", "primary", "Key of heartbeat row, primaries sharing heartbeat table need different ones.")
+	heartbeatMode    = flag.String("db.heartbeat-mode", "auto", "Heartbeat mode: auto writes on primary and reads on standby, read only reads.")
+	logDirectory     = flag.String("log.directory", "", "Directory with csvlog or jsonlog files of the server to follow, disabled by default.")
+	logFormat        = flag.String("log.format", "csvlog", "Format of server log files: csvlog or jsonlog.")
+	queries          = flag.String("queries.config-path", "", "Path to yaml files with custom queries")
+	metricsNamespace = flag.String("metrics.namespace", "postgresql", "Prefix of metric names.")
+	constLabels      = flag.String("metrics.const-labels", "", "Comma-separated list of name=value labels added to all metrics.")
+	metricsAllow     = flag.String("metrics.allow", "", "Regexp of metric names to export, all by default.")
+	metricsDeny      = flag.String("metrics.deny", "", "Regexp of metric names not to export.")
+	configFile       = flag.String("config.file", "", "Path to yaml file with targets, DATA_SOURCE_NAME, db.names and db.tables are ignored when set.")
 )
 
 type Exporter struct {
 	m                sync.Mutex
-	dsn              string
-	metrics          []metrics.Collection
+	targets          []*target
 	totalScrapes     prometheus.Counter
 	duration, errors prometheus.Gauge
 }
 
-func NewPostgreSQLExporter(dsn string, cq []metrics.CustomQuery) *Exporter {
-	e := &Exporter{
-		dsn: dsn,
-		metrics: []metrics.Collection{
-			metrics.NewBufferMetrics(),
-			metrics.NewDBMetrics(strings.Split(*databases, ",")),
-			metrics.NewSlowQueryMetrics(*slow),
-			metrics.NewCustomQueryMetrics(cq),
-		},
+func NewPostgreSQLExporter(targets []*target, constLabels map[string]string) *Exporter {
+	return &Exporter{
+		targets: targets,
 		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "exporter_scrapes_total",
-			Help:      "Current total postgresql scrapes.",
+			Namespace:   namespace,
+			Name:        "exporter_scrapes_total",
+			Help:        "Current total postgresql scrapes.",
+			ConstLabels: constLabels,
 		}),
 		duration: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "exporter_last_scrape_duration_seconds",
-			Help:      "The last scrape duration.",
+			Namespace:   namespace,
+			Name:        "exporter_last_scrape_duration_seconds",
+			Help:        "The last scrape duration.",
+			ConstLabels: constLabels,
 		}),
 		errors: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "exporter_last_scrape_error",
-			Help:      "The last scrape error status.",
+			Namespace:   namespace,
+			Name:        "exporter_last_scrape_error",
+			Help:        "The last scrape error status.",
+			ConstLabels: constLabels,
 		}),
 	}
+}
+
+// compilePattern returns nil for empty pattern, so it matches everything
+func compilePattern(name, pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
 
-	if len(*tables) > 0 {
-		e.metrics = append(e.metrics, metrics.NewTableMetrics(strings.Split(*tables, ",")))
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatalf("invalid %s pattern: %s", name, err)
 	}
 
-	return e
+	return re
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	for _, m := range e.metrics {
-		m.Describe(ch)
+	for _, t := range e.targets {
+		t.describe(ch)
 	}
 
 	ch <- e.duration.Desc()
@@ -98,8 +133,8 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	ch <- e.totalScrapes
 	ch <- e.errors
 
-	for _, m := range e.metrics {
-		m.Collect(ch)
+	for _, t := range e.targets {
+		t.collect(ch)
 	}
 }
 
@@ -107,63 +142,147 @@ func (e *Exporter) scrape() {
 	now := time.Now().UnixNano()
 
 	e.totalScrapes.Inc()
-	for _, m := range e.metrics {
-		err := m.Scrape(db)
+	failed := false
+	for _, t := range e.targets {
+		err := t.scrape()
 		if err != nil {
-			log.Println(err)
-			e.errors.Set(1)
-			e.duration.Set(float64(time.Now().UnixNano()-now) / 1000000000)
-			return
+			log.Println(scrapeErrorMessage(t.name, err))
+			failed = true
 		}
 	}
 
-	e.errors.Set(0)
+	if failed {
+		e.errors.Set(1)
+	} else {
+		e.errors.Set(0)
+	}
 	e.duration.Set(float64(time.Now().UnixNano()-now) / 1000000000)
 }
 
+// scrapeErrorMessage returns error of target for log with secrets redacted
+func scrapeErrorMessage(name string, err error) string {
+	if name != "" {
+		err = fmt.Errorf("target %s: %s", name, err)
+	}
+
+	return redact(err.Error())
+}
+
 // check interface
 var _ prometheus.Collector = new(Exporter)
 
 func main() {
 	flag.Parse()
+	takeClientEnv()
+
+	var cfg Config
+	if *configFile != "" {
+		cfg = parseConfig(*configFile)
+	} else {
+		dsn := os.Getenv("DATA_SOURCE_NAME")
+		if len(dsn) == 0 {
+			log.Fatal("couldn't find environment variable DATA_SOURCE_NAME")
+		}
+
+		if *databases == "" {
+			log.Fatal("please specify at least one database")
+		}
 
-	dsn := os.Getenv("DATA_SOURCE_NAME")
-	if len(dsn) == 0 {
-		log.Fatal("couldn't find environment variable DATA_SOURCE_NAME")
+		tc := TargetConfig{
+			Type:            targetPostgreSQL,
+			DSN:             dsn,
+			Databases:       strings.Split(*databases, ","),
+			UserFile:        os.Getenv("DATA_SOURCE_USER_FILE"),
+			PasswordFile:    os.Getenv("DATA_SOURCE_PASS_FILE"),
+			PasswordCommand: os.Getenv("DATA_SOURCE_PASS_COMMAND"),
+			LogDirectory:    *logDirectory,
+			LogFormat:       *logFormat,
+			HeartbeatMode:   *heartbeatMode,
+		}
+		checkHeartbeatMode(tc.HeartbeatMode)
+		if tc.LogFormat != logFormatCSV && tc.LogFormat != logFormatJSON {
+			log.Fatalf("unknown log format %q", tc.LogFormat)
+		}
+		if len(*tables) > 0 {
+			tc.Tables = strings.Split(*tables, ",")
+		}
+		cfg.Targets = []TargetConfig{tc}
 	}
 
-	if *databases == "" {
-		log.Fatal("please specify at least one database")
+	checkNamespace(*metricsNamespace)
+	namespace = *metricsNamespace
+	if cfg.Namespace != "" {
+		namespace = cfg.Namespace
 	}
+	metrics.SetNamespace(namespace)
 
-	var err error
-	db, err = sql.Open("postgres", dsn)
-	if err != nil {
-		log.Fatal("error opening connection to database: ", err)
+	labels := parseLabels(*constLabels)
+	for name, value := range cfg.ConstLabels {
+		labels[name] = value
 	}
-	defer db.Close()
 
-	if err := db.Ping(); err != nil {
-		log.Fatal("error opening connection to database: ", err)
+	if *metricsAllow != "" {
+		cfg.Filter.Allow = append(cfg.Filter.Allow, *metricsAllow)
+	}
+	if *metricsDeny != "" {
+		cfg.Filter.Deny = append(cfg.Filter.Deny, *metricsDeny)
+	}
+	filter := newMetricFilter(cfg.Filter)
+	metrics.SetMetricFilter(filter.keepMetric)
+
+	if *heartbeatTable != "" && !tableNameRe.MatchString(*heartbeatTable) {
+		log.Fatalf("invalid heartbeat table name %q", *heartbeatTable)
 	}
 
-	db.SetMaxIdleConns(5)
-	db.SetMaxOpenConns(5)
+	cq := parseQueries(*queries)
+	var targets []*target
+	for _, tc := range cfg.Targets {
+		db := tc.open("")
+		tc.configurePool(db)
 
-	exporter := NewPostgreSQLExporter(dsn, parseQueries(*queries))
+		targets = append(targets, newTarget(tc, db, cq, labels, filter))
+	}
+
+	exporter := NewPostgreSQLExporter(targets, labels)
 	prometheus.MustRegister(exporter)
 	http.Handle(*metricPath, prometheus.Handler())
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/ready", readyHandler(targets, *readyTimeout))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 <head><title>PostgreSQL exporter</title></head>
 <body>
 <h1>PostgreSQL exporter</h1>
 <p><a href='` + *metricPath + `'>Metrics</a></p>
+<p><a href='/healthz'>Health</a></p>
+<p><a href='/ready'>Readiness</a></p>
 </body>
 </html>
 `))
 	})
 
+	server := &http.Server{Addr: *listenAddress}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("error shutting down server: %s", err)
+		}
+	}()
+
 	log.Infof("Starting Server: %s", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	// connections are closed, so the server doesn't wait for their timeouts
+	for _, t := range targets {
+		t.close()
+	}
+	log.Infof("Server stopped")
 }
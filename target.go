@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/log"
+
+	"github.com/mc2soft/postgresql_exporter/metrics"
+)
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+// collection is a metrics collection with name used in filter limits
+type collection struct {
+	name string
+	metrics.Collection
+}
+
+// independent tells whether collection needs no database connection
+func (c collection) independent() bool {
+	_, ok := c.Collection.(metrics.Independent)
+	return ok
+}
+
+// target is a monitored PostgreSQL server or PgBouncer
+type target struct {
+	name        string
+	db          *sql.DB
+	collections []collection
+	labels      []*dto.LabelPair
+	filter      *metricFilter
+
+	// query to check connection, admin console of PgBouncer supports only SHOW commands
+	pingQuery string
+	backoff   time.Duration
+	retryAt   time.Time
+	// reachable is result of the last connection check, up metric value
+	reachable  bool
+	up         prometheus.Gauge
+	connErrors *prometheus.CounterVec
+	dropped    *prometheus.CounterVec
+	pool       *poolStats
+	// dbs are pools of monitored databases, nil for PgBouncer
+	dbs *databasePool
+}
+
+// newTarget creates target, its metrics get global constant labels,
+// overridden by constant labels of the target, and target name label
+func newTarget(cfg TargetConfig, db *sql.DB, cq []metrics.CustomQuery, constLabels map[string]string, filter *metricFilter) *target {
+	t := &target{
+		name:      cfg.Name,
+		db:        db,
+		filter:    filter,
+		pool:      newPoolStats(),
+		pingQuery: "SELECT 1",
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "up",
+			Help:      "Whether the last connection check to the target was successful.",
+		}),
+		connErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_connection_errors_total",
+			Help:      "Total connection errors by error class.",
+		}, []string{"class"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_series_dropped_total",
+			Help:      "Total series dropped because collection exceeded its limit.",
+		}, []string{"collection"}),
+	}
+
+	labels := make(map[string]string)
+	for name, value := range constLabels {
+		labels[name] = value
+	}
+	for name, value := range cfg.ConstLabels {
+		labels[name] = value
+	}
+	if cfg.Name != "" {
+		labels["target"] = cfg.Name
+	}
+	for name, value := range labels {
+		t.labels = append(t.labels, &dto.LabelPair{
+			Name:  proto.String(name),
+			Value: proto.String(value),
+		})
+	}
+
+	if cfg.Type == targetPgBouncer {
+		t.pingQuery = "SHOW VERSION"
+		for _, c := range metrics.NewPgBouncerCollections() {
+			t.collections = append(t.collections, collection{"pgbouncer", c})
+		}
+		return t
+	}
+
+	dbs := newDatabasePool(cfg)
+	t.dbs = dbs
+	t.collections = []collection{
+		{"server", metrics.NewServerMetrics()},
+		{"buffers", metrics.NewBufferMetrics()},
+		{"databases", metrics.NewDBMetrics(cfg.Databases, *windowedRatio)},
+		{"slow_queries", metrics.NewSlowQueryMetrics(*slow)},
+		// custom queries keep their state, so each target needs its own copy
+		{"custom", metrics.NewCustomQueryMetrics(append([]metrics.CustomQuery(nil), cq...))},
+		{"progress", metrics.NewProgressMetrics()},
+		{"io", metrics.NewIOMetrics()},
+		{"xact", metrics.NewXactMetrics()},
+		{"disk", metrics.NewDiskMetrics()},
+		{"stats_resets", metrics.NewStatsResetMetrics(cfg.Databases)},
+		{"roles", metrics.NewRoleMetrics()},
+		{"security", metrics.NewSecurityMetrics()},
+	}
+
+	if *extensions {
+		t.collections = append(t.collections, collection{"extensions", metrics.NewExtensionMetrics(cfg.Databases, dbs.get)})
+	}
+
+	if *waitSampling > 0 {
+		t.collections = append(t.collections, collection{"wait_events", metrics.NewWaitEventMetrics(*waitSampling)})
+	}
+
+	if cfg.LogDirectory != "" {
+		t.collections = append(t.collections, collection{"log", metrics.NewLogMetrics(cfg.LogDirectory, cfg.LogFormat)})
+	}
+
+	if *schema {
+		t.collections = append(t.collections, collection{"schema", metrics.NewSchemaMetrics(cfg.Databases, dbs.get, *schemaInterval)})
+	}
+
+	if *functions {
+		t.collections = append(t.collections, collection{"functions", metrics.NewFunctionMetrics(
+			compilePattern("db.functions-include", *funcInclude),
+			compilePattern("db.functions-exclude", *funcExclude),
+			*funcLimit,
+		)})
+	}
+
+	if len(cfg.Tables) > 0 {
+		tableMetrics := metrics.NewTableMetrics(cfg.Tables, *windowedRatio, *partitionRollup, *keepPartitions)
+		t.collections = append(t.collections, collection{"tables", tableMetrics})
+		if *bloat {
+			t.collections = append(t.collections, collection{"bloat", metrics.NewBloatMetrics(tableMetrics, *bloatExact, *bloatInterval)})
+		}
+	}
+
+	if *heartbeatTable != "" {
+		t.collections = append(t.collections, collection{"heartbeat", metrics.NewHeartbeatMetrics(*heartbeatTable, *heartbeatID, cfg.HeartbeatMode)})
+	}
+
+	return t
+}
+
+// connect checks connection to the target. After failure next attempt is
+// made not earlier than backoff time, which doubles after each failure.
+func (t *target) connect() error {
+	if time.Now().Before(t.retryAt) {
+		return errors.New("database is unreachable, next connection attempt at " + t.retryAt.Format(time.RFC3339))
+	}
+
+	err := t.ping(context.Background())
+	if err != nil {
+		t.connErrors.WithLabelValues(errorClass(err)).Inc()
+		t.reachable = false
+		t.up.Set(0)
+
+		t.backoff *= 2
+		if t.backoff < minReconnectBackoff {
+			t.backoff = minReconnectBackoff
+		}
+		if t.backoff > maxReconnectBackoff {
+			t.backoff = maxReconnectBackoff
+		}
+		t.retryAt = time.Now().Add(t.backoff)
+
+		return errors.New("error connecting to database: " + err.Error())
+	}
+
+	t.backoff = 0
+	t.reachable = true
+	t.up.Set(1)
+
+	return nil
+}
+
+// ping runs a query on the target, so connection is actually used
+func (t *target) ping(ctx context.Context) error {
+	rows, err := t.db.QueryContext(ctx, t.pingQuery)
+	if err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
+
+// scrape runs collections, they are skipped when all their metrics are
+// filtered out, or while the target is unreachable unless they need no database
+func (t *target) scrape() error {
+	// error of one collection doesn't stop the rest, e.g. when privileges
+	// for some views are missing
+	var messages []string
+	err := t.connect()
+	if err != nil {
+		messages = append(messages, err.Error())
+	}
+
+	for _, c := range t.collections {
+		if !t.filter.keepCollection(c) || (!t.reachable && !c.independent()) {
+			continue
+		}
+		err := c.Scrape(t.db)
+		if err != nil {
+			messages = append(messages, c.name+": "+err.Error())
+		}
+	}
+
+	if t.reachable && t.dbs != nil {
+		err := t.dbs.prune(t.db)
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+// close closes connections pools of the target
+func (t *target) close() {
+	if t.dbs != nil {
+		t.dbs.close()
+	}
+	t.db.Close()
+}
+
+func (t *target) describe(ch chan<- *prometheus.Desc) {
+	t.up.Describe(ch)
+	t.connErrors.Describe(ch)
+	t.dropped.Describe(ch)
+	t.pool.describe(ch)
+	for _, c := range t.collections {
+		c.Describe(ch)
+	}
+}
+
+// collect sends metrics of all collections adding target labels to them,
+// exporter's own metrics go last, so they count series dropped by this scrape.
+// Values of collections using database aren't sent while the target is
+// unreachable, as they are left from the last successful scrape.
+func (t *target) collect(ch chan<- prometheus.Metric) {
+	for _, c := range t.collections {
+		if t.reachable || c.independent() {
+			t.send(ch, c.name, c.Collect)
+		}
+	}
+
+	t.send(ch, "", func(ch chan<- prometheus.Metric) {
+		t.up.Collect(ch)
+		t.connErrors.Collect(ch)
+		t.dropped.Collect(ch)
+		t.pool.collect(t.db, "", ch)
+		if t.dbs != nil {
+			for name, db := range t.dbs.pools() {
+				t.pool.collect(db, name, ch)
+			}
+		}
+	})
+}
+
+// send filters metrics of named collection and adds target labels to them
+func (t *target) send(ch chan<- prometheus.Metric, name string, collect func(chan<- prometheus.Metric)) {
+	mch := make(chan prometheus.Metric)
+	go func() {
+		collect(mch)
+		close(mch)
+	}()
+
+	limit := t.filter.limit(name)
+	count, dropped := 0, 0
+	for m := range mch {
+		metricName := descName(m.Desc())
+		if !t.filter.keepMetric(metricName) {
+			continue
+		}
+		if len(t.labels) == 0 && !t.filter.needsSeries(name) {
+			ch <- m
+			continue
+		}
+
+		out := &dto.Metric{}
+		err := m.Write(out)
+		if err != nil {
+			// registry reports the error writing metric again
+			ch <- m
+			continue
+		}
+		out.Label = withLabels(out.Label, t.labels)
+		if !t.filter.keepSeries(metricName, out.Label) {
+			continue
+		}
+		if limit > 0 && count >= limit {
+			dropped++
+			continue
+		}
+		count++
+
+		ch <- writtenMetric{desc: m.Desc(), metric: out}
+	}
+
+	if dropped > 0 {
+		log.Warnf("collection %s of target %q exceeded limit of %d series, %d series dropped", name, t.name, limit, dropped)
+		t.dropped.WithLabelValues(name).Add(float64(dropped))
+	}
+}
+
+// writtenMetric is a metric which value was already written
+type writtenMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (m writtenMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m writtenMetric) Write(out *dto.Metric) error {
+	*out = *m.metric
+	return nil
+}
+
+// withLabels adds constant labels to labels of metric, labels of the metric
+// itself take precedence
+func withLabels(labels, constLabels []*dto.LabelPair) []*dto.LabelPair {
+	if len(constLabels) == 0 {
+		return labels
+	}
+
+	// metrics may share label pairs between writes, so never append in place
+	result := make([]*dto.LabelPair, 0, len(labels)+len(constLabels))
+	result = append(result, labels...)
+	for _, label := range constLabels {
+		if !hasLabel(labels, label.GetName()) {
+			result = append(result, label)
+		}
+	}
+	sort.Sort(prometheus.LabelPairSorter(result))
+
+	return result
+}
+
+func hasLabel(labels []*dto.LabelPair, name string) bool {
+	for _, label := range labels {
+		if label.GetName() == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collectNames returns names of metrics collected from target
+func collectNames(tg *target) map[string]bool {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		tg.collect(ch)
+		close(ch)
+	}()
+
+	names := make(map[string]bool)
+	for m := range ch {
+		names[descName(m.Desc())] = true
+	}
+
+	return names
+}
+
+func TestCollectUnreachable(t *testing.T) {
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 user=exporter sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tg := newTarget(TargetConfig{LogDirectory: t.TempDir()}, db, nil, nil, nil)
+	if err := tg.scrape(); err == nil {
+		t.Fatal("expected connection error")
+	}
+
+	names := collectNames(tg)
+	for name, exported := range map[string]bool{
+		"postgresql_up": true,
+		"postgresql_exporter_connection_errors_total": true,
+		// log is read without database
+		"postgresql_log_checkpoint_warnings_total": true,
+		// values of collections using database are stale
+		"postgresql_slow_queries_total": false,
+	} {
+		if names[name] != exported {
+			t.Errorf("%s exported %t, expected %t", name, names[name], exported)
+		}
+	}
+
+	pb := &dto.Metric{}
+	tg.up.Write(pb)
+	if pb.GetGauge().GetValue() != 0 {
+		t.Fatal("expected up to be 0")
+	}
+}